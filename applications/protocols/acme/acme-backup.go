@@ -0,0 +1,97 @@
+package acme
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mygolibs/encrypt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+)
+
+// Backup writes the acme dir, accounts, keys and certificates, as a tar.gz
+// encrypted to recipients, so it can leave the host:
+//
+//	age -d -i key.txt acme.tar.gz.age | tar xz
+//
+// Paths in the archive are relative to the parent of the dir, ".acme/...".
+// Private keys saved with key_passphrase stay encrypted inside.
+func Backup(w io.Writer, recipients ...age.Recipient) error {
+	enc, err := encrypt.AgeEncryptWriter(w, false, recipients...)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(enc)
+	if err := writeTar(gz, getDir()); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// BackupFile is Backup to a new file, removed again on failure.
+func BackupFile(path string, recipients ...age.Recipient) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = Backup(f, recipients...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(filepath.Clean(dir))
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"encoding/json"
 	"os"
 
 	"crypto"
@@ -16,7 +17,7 @@ type ACMEExternalBinding struct {
 type ACMEAccount struct {
 	AccountUrl      string
 	ExternalBinding ACMEExternalBinding
-	PrivKey         crypto.PrivateKey
+	PrivKey         crypto.PrivateKey // any crypto.Signer, may be held by a remote signing service
 	Kid             string
 	Contact         []string
 	Platform        string
@@ -44,9 +45,9 @@ type AcmeDirectory struct {
 }
 
 type AcmeNewAccountPayload struct {
-	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
-	Contact              []string `json:"contact"`
-	// externalAccountBinding ExternalAccountBinding
+	TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+	Contact                []string        `json:"contact"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"` // see ExternalAccountBindingJWS
 }
 
 type AcmeNewAccount struct {
@@ -142,10 +143,13 @@ type StellarModuleAcme struct {
 	Dir                    string                `yaml:"dir"`
 	ExternalAccountBinding []ACMEExternalBinding `yaml:"external_account_binding"`
 	ExpireCheckDuration    int                   `yaml:"expire_check_duration"`
+	KeyPassphrase          string                `yaml:"key_passphrase"` // encrypts saved private keys when set
 }
 type StellarModuleDNS struct {
-	Provider      string      `yaml:"provider"`
-	Authorization interface{} `yaml:"auth"`
+	Provider           string      `yaml:"provider"`
+	Authorization      interface{} `yaml:"auth"`
+	PropagationTimeout int         `yaml:"propagation_timeout"`
+	PollingInterval    int         `yaml:"polling_interval"`
 }
 
 func LoadConf(filepath string, cnf interface{}) error {
@@ -0,0 +1,190 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSProvider creates and removes the `_acme-challenge` TXT record for dns-01.
+// fqdn always ends with a dot, value is the digest expected by the CA.
+type DNSProvider interface {
+	Present(fqdn string, value string) error
+	CleanUp(fqdn string, value string) error
+}
+
+// DNSPropagationOption controls how long we wait for the TXT record to show up
+// on every authoritative nameserver before asking the CA to validate.
+type DNSPropagationOption struct {
+	Timeout     time.Duration
+	Interval    time.Duration
+	Nameservers []string // override, "host:port"; empty means lookup the zone's NS records
+}
+
+const (
+	defaultPropagationTimeout  = 2 * time.Minute
+	defaultPropagationInterval = 5 * time.Second
+)
+
+var (
+	ErrDNSPropagationTimeout = errors.New("acme: timed out waiting for dns-01 record propagation")
+	ErrDNSNameservers        = errors.New("acme: cannot find authoritative nameservers")
+)
+
+// PropagationOption builds a DNSPropagationOption from config, values are seconds.
+func (c StellarModuleDNS) PropagationOption() DNSPropagationOption {
+	option := DNSPropagationOption{
+		Timeout:  time.Duration(c.PropagationTimeout) * time.Second,
+		Interval: time.Duration(c.PollingInterval) * time.Second,
+	}
+	if option.Timeout <= 0 {
+		option.Timeout = defaultPropagationTimeout
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultPropagationInterval
+	}
+	return option
+}
+
+// DNS01ChallengeRecord returns the record name and TXT value for a dns-01 challenge.
+// See https://tools.ietf.org/html/rfc8555#section-8.4
+func DNS01ChallengeRecord(domain string, token string, accountKey crypto.PublicKey) (string, string, error) {
+	thumbprint, err := JWKThumbprint(accountKey)
+	if err != nil {
+		return "", "", err
+	}
+	keyAuth := token + "." + thumbprint
+	digest := sha256.Sum256([]byte(keyAuth))
+	domain = strings.TrimPrefix(domain, "*.")
+	fqdn := "_acme-challenge." + strings.TrimSuffix(domain, ".") + "."
+	return fqdn, base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+// authoritativeNameservers walks up fqdn until a zone with NS records is found.
+func authoritativeNameservers(ctx context.Context, fqdn string) ([]string, error) {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	var lastErr error
+	for i := 0; i < len(labels)-1; i++ {
+		zone := strings.Join(labels[i:], ".")
+		nss, err := net.DefaultResolver.LookupNS(ctx, zone)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(nss) == 0 {
+			continue
+		}
+		servers := make([]string, len(nss))
+		for j, ns := range nss {
+			servers[j] = net.JoinHostPort(strings.TrimSuffix(ns.Host, "."), "53")
+		}
+		return servers, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrDNSNameservers, fqdn, lastErr)
+	}
+	return nil, fmt.Errorf("%w for %s", ErrDNSNameservers, fqdn)
+}
+
+// lookupTXTAt queries a single nameserver directly, bypassing any local cache.
+func lookupTXTAt(ctx context.Context, nameserver string, fqdn string) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, nameserver)
+		},
+	}
+	return resolver.LookupTXT(ctx, fqdn)
+}
+
+// WaitForDNSPropagation polls every authoritative nameserver until all of them
+// serve value at fqdn, or option.Timeout expires.
+func WaitForDNSPropagation(fqdn string, value string, option DNSPropagationOption) error {
+	if option.Timeout <= 0 {
+		option.Timeout = defaultPropagationTimeout
+	}
+	if option.Interval <= 0 {
+		option.Interval = defaultPropagationInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), option.Timeout)
+	defer cancel()
+
+	nameservers := option.Nameservers
+	if len(nameservers) == 0 {
+		var err error
+		nameservers, err = authoritativeNameservers(ctx, fqdn)
+		if err != nil {
+			return err
+		}
+	}
+	pending := make(map[string]bool, len(nameservers))
+	for _, ns := range nameservers {
+		pending[ns] = true
+	}
+	for {
+		for ns := range pending {
+			records, err := lookupTXTAt(ctx, ns, fqdn)
+			if err != nil {
+				continue
+			}
+			for _, record := range records {
+				if record == value {
+					delete(pending, ns)
+					break
+				}
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ErrDNSPropagationTimeout
+		case <-time.After(option.Interval):
+		}
+	}
+}
+
+// SolveDNS01Challenge presents the TXT record through provider, waits for it to
+// propagate and then asks the CA to validate the challenge.
+// The record is left in place, call provider.CleanUp once the authz is final.
+func SolveDNS01Challenge(provider DNSProvider, authz AcmeAuthz, option ACMERequestOption, propagation DNSPropagationOption) (*AcmeChall, error) {
+	var chall *AcmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "dns-01" {
+			chall = &authz.Challenges[i]
+			break
+		}
+	}
+	if chall == nil {
+		return nil, errors.New("acme: no dns-01 challenge offered")
+	}
+	signer, ok := option.Account.PrivKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	fqdn, value, err := DNS01ChallengeRecord(authz.Identifier.Value, chall.Token, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.Present(fqdn, value); err != nil {
+		return nil, err
+	}
+	if err := WaitForDNSPropagation(fqdn, value, propagation); err != nil {
+		return nil, err
+	}
+	option.Payload = struct{}{}
+	result := &AcmeChall{}
+	_, err = ACMEPostRequestWithKid(chall.Url, option, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package acme
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacyFile(t *testing.T) {
+	account := ACMEAccount{Contact: []string{"me@example.com"}, Platform: "letsencrypt"}
+	// the legacy paths are relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	dir := "./.acme"
+	legacy := legacyUserPrivKeyPath(dir, account)
+	if legacy != `"./.acme"/account/"me@example.com"."letsencrypt".pem` {
+		t.Fatalf("legacy path %s", legacy)
+	}
+	if err := os.MkdirAll(filepath.Dir(legacy), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := userPrivKeyPath(dir, account)
+	if err := migrateLegacyFile(path, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old key" {
+		t.Fatalf("migrated %q %v", data, err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("legacy file left: %v", err)
+	}
+
+	// a file at the new path wins over a legacy one
+	os.WriteFile(legacy, []byte("stale key"), 0600)
+	if err := migrateLegacyFile(path, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old key" {
+		t.Fatalf("overwritten with %q", data)
+	}
+
+	// nothing to migrate
+	authz := AcmeAuthz{}
+	authz.Identifier.Value = "svc.example.com"
+	if err := migrateLegacyFile(certPrivKeyPath(dir, authz), legacyCertPrivKeyPath(dir, authz)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(certPrivKeyPath(dir, authz)); !os.IsNotExist(err) {
+		t.Fatalf("file created: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package acme
+
+import (
+	"mygolibs/pki"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// CertNeedsRenewal reports whether the leaf of chainPEM expires within
+// before, or when before is 0, has less than a third of its lifetime left as
+// Let's Encrypt recommends. The returned info is for logging.
+func CertNeedsRenewal(chainPEM []byte, before time.Duration, now time.Time) (bool, pki.CertInfo, error) {
+	certs, err := pki.ParseCertificatesPEM(chainPEM)
+	if err != nil {
+		return false, pki.CertInfo{}, err
+	}
+	leaf := certs[0]
+	if before == 0 {
+		before = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+	info := pki.InspectCertificate(leaf, pki.InspectOptions{ExpiryWarning: before, Now: now})
+	return !now.Before(leaf.NotAfter.Add(-before)), info, nil
+}
+
+// CertNeedsRenewalOCSP is CertNeedsRenewal that also asks the OCSP responder
+// of the leaf, through the stapler cache, and renews a revoked certificate
+// right away. The responder being unreachable does not force a renewal.
+// Without a stapler it is CertNeedsRenewal.
+func CertNeedsRenewalOCSP(chainPEM []byte, before time.Duration, now time.Time, stapler *pki.OCSPStapler) (bool, pki.CertInfo, error) {
+	renew, info, err := CertNeedsRenewal(chainPEM, before, now)
+	if err != nil || renew || stapler == nil {
+		return renew, info, err
+	}
+	certs, err := pki.ParseCertificatesPEM(chainPEM)
+	if err != nil || len(certs) < 2 {
+		return false, info, err
+	}
+	resp, _, err := stapler.Status(certs[0], certs[1])
+	if err != nil {
+		return false, info, nil
+	}
+	return resp.Status == ocsp.Revoked, info, nil
+}
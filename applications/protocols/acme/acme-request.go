@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"crypto"
 	"errors"
 
 	"github.com/go-resty/resty/v2"
@@ -44,12 +45,13 @@ func requiredACMEOptionCheck(option ACMERequestOption) error {
 }
 
 // PostAsGet: option.Payload = ""
+// Signs with the account key embedded as "jwk", only newAccount and
+// revokeCert accept that, use ACMEPostRequestWithKid for the rest.
 func ACMEPostRequest(url string, option ACMERequestOption, result interface{}) (*resty.Response, error) {
 	err := requiredACMEOptionCheck(option)
 	if err != nil {
 		return nil, err
 	}
-	client := resty.New()
 	nonce, err := AcmeNewNonce(option.Dirs.NewNonce)
 	if err != nil {
 		return nil, err
@@ -58,6 +60,41 @@ func ACMEPostRequest(url string, option ACMERequestOption, result interface{}) (
 	if err != nil {
 		return nil, err
 	}
+	return acmePost(url, reqBody, result)
+}
+
+// ACMEPostRequestWithKid signs with the account URL as "kid", which RFC 8555
+// requires once the account exists.
+// See https://tools.ietf.org/html/rfc8555#section-6.2
+func ACMEPostRequestWithKid(url string, option ACMERequestOption, result interface{}) (*resty.Response, error) {
+	err := requiredACMEOptionCheck(option)
+	if err != nil {
+		return nil, err
+	}
+	if option.Account.Kid == "" {
+		return nil, errors.New("the ACME account has no kid, register it first")
+	}
+	key, ok := option.Account.PrivKey.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	nonce, err := AcmeNewNonce(option.Dirs.NewNonce)
+	if err != nil {
+		return nil, err
+	}
+	payload := option.Payload
+	if payload == "" {
+		payload = nil // POST-as-GET, empty payload
+	}
+	reqBody, err := JwsEncodeJSONWithKid(payload, key, nonce, url, option.Account.Kid)
+	if err != nil {
+		return nil, err
+	}
+	return acmePost(url, reqBody, result)
+}
+
+func acmePost(url string, reqBody []byte, result interface{}) (*resty.Response, error) {
+	client := resty.New()
 	resp, err := client.R().
 		SetHeader("Content-Type", "application/jose+json").
 		ForceContentType("application/json").
@@ -0,0 +1,265 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"mygolibs/encrypt"
+)
+
+var (
+	errBadSignatureAlgorithm = errors.New("acme: unsupported JWS algorithm")
+	errBadSignature          = errors.New("acme: JWS signature verification failed")
+)
+
+// minRSAKeyBits is the smallest RSA account key accepted, RS256 is the only
+// RSA algorithm so the key size is all that sets its strength.
+const minRSAKeyBits = 2048
+
+// jwsFlattened is the flattened JSON serialization used by every ACME POST.
+// https://tools.ietf.org/html/rfc7515#section-7.2.2
+type jwsFlattened struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Sig       string `json:"signature"`
+}
+
+type jwsProtectedHeader struct {
+	Alg   string          `json:"alg"`
+	Jwk   json.RawMessage `json:"jwk"`
+	Kid   string          `json:"kid"`
+	Nonce string          `json:"nonce"`
+	Url   string          `json:"url"`
+}
+
+// jwkDecode parses the public RSA, ECDSA or Ed25519 key out of a JWK.
+func jwkDecode(raw []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, err
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("acme: invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("acme: RSA key is %d bits, at least %d required", pub.N.BitLen(), minRSAKeyBits)
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("acme: EC point is not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("acme: invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// jwsVerify checks the signature of a flattened JWS against pub.
+func jwsVerify(jws *jwsFlattened, alg string, pub crypto.PublicKey) error {
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Sig)
+	if err != nil {
+		return err
+	}
+	input := []byte(jws.Protected + "." + jws.Payload)
+	switch alg {
+	case "RS256":
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errBadSignatureAlgorithm
+		}
+		digest := sha256.Sum256(input)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return errBadSignature
+		}
+		return nil
+	case "ES256", "ES384", "ES512":
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return errBadSignatureAlgorithm
+		}
+		var digest []byte
+		switch {
+		case alg == "ES256" && key.Curve == elliptic.P256():
+			d := sha256.Sum256(input)
+			digest = d[:]
+		case alg == "ES384" && key.Curve == elliptic.P384():
+			d := sha512.Sum384(input)
+			digest = d[:]
+		case alg == "ES512" && key.Curve == elliptic.P521():
+			d := sha512.Sum512(input)
+			digest = d[:]
+		default:
+			return errBadSignatureAlgorithm
+		}
+		// JWS carries the raw r||s pair, not ASN.1 DER.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errBadSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errBadSignature
+		}
+		return nil
+	case "EdDSA":
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errBadSignatureAlgorithm
+		}
+		if !ed25519.Verify(key, input, sig) {
+			return errBadSignature
+		}
+		return nil
+	}
+	return errBadSignatureAlgorithm
+}
+
+// ExternalAccountBindingJWS builds the externalAccountBinding member of a
+// new-account request, binding accountKey to the CA provided MAC key.
+// See https://tools.ietf.org/html/rfc8555#section-7.3.4
+func ExternalAccountBindingJWS(eab ACMEExternalBinding, accountKey crypto.PublicKey, url string) (json.RawMessage, error) {
+	jwk, err := json.Marshal(encrypt.JWK{Key: accountKey})
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := base64.RawURLEncoding.DecodeString(eab.HmacKey)
+	if err != nil {
+		return nil, fmt.Errorf("acme: invalid EAB hmac key: %w", err)
+	}
+	phead := fmt.Sprintf(`{"alg":"HS256","kid":%q,"url":%q}`, eab.Kid, url)
+	phead = base64.RawURLEncoding.EncodeToString([]byte(phead))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(jwk))
+	h := hmac.New(sha256.New, macKey)
+	h.Write([]byte(phead + "." + payload))
+	enc := jwsFlattened{
+		Protected: phead,
+		Payload:   payload,
+		Sig:       base64.RawURLEncoding.EncodeToString(h.Sum(nil)),
+	}
+	return json.Marshal(&enc)
+}
+
+// verifyExternalAccountBinding checks an EAB JWS against the configured MAC keys
+// and makes sure it binds the same key that signed the outer request.
+func verifyExternalAccountBinding(raw json.RawMessage, bindings []ACMEExternalBinding, accountKey crypto.PublicKey, url string) (string, error) {
+	var jws jwsFlattened
+	if err := json.Unmarshal(raw, &jws); err != nil {
+		return "", err
+	}
+	headBytes, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return "", err
+	}
+	var head jwsProtectedHeader
+	if err := json.Unmarshal(headBytes, &head); err != nil {
+		return "", err
+	}
+	if head.Alg != "HS256" || head.Url != url || head.Nonce != "" {
+		return "", errors.New("acme: malformed externalAccountBinding header")
+	}
+	var binding *ACMEExternalBinding
+	for i := range bindings {
+		if bindings[i].Kid == head.Kid {
+			binding = &bindings[i]
+			break
+		}
+	}
+	if binding == nil {
+		return "", fmt.Errorf("acme: unknown EAB key id %q", head.Kid)
+	}
+	macKey, err := base64.RawURLEncoding.DecodeString(binding.HmacKey)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Sig)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, macKey)
+	h.Write([]byte(jws.Protected + "." + jws.Payload))
+	if !hmac.Equal(h.Sum(nil), sig) {
+		return "", errBadSignature
+	}
+	jwkBytes, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return "", err
+	}
+	boundKey, err := jwkDecode(jwkBytes)
+	if err != nil {
+		return "", err
+	}
+	boundPrint, err := JWKThumbprint(boundKey)
+	if err != nil {
+		return "", err
+	}
+	accountPrint, err := JWKThumbprint(accountKey)
+	if err != nil {
+		return "", err
+	}
+	if boundPrint != accountPrint {
+		return "", errors.New("acme: externalAccountBinding does not match account key")
+	}
+	return head.Kid, nil
+}
@@ -0,0 +1,127 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const validationTimeout = 30 * time.Second
+
+// validate runs a challenge and updates challenge, authz and order status.
+// Only a pending authz is changed.
+func (s *ACMEServer) validate(challID string, accountKey crypto.PublicKey) {
+	s.mu.Lock()
+	chall := s.challs[challID]
+	authz := s.authzs[chall.authz]
+	domain := authz.identifier.Value
+	typ, token := chall.typ, chall.token
+	s.mu.Unlock()
+
+	var err error
+	thumbprint, err := JWKThumbprint(accountKey)
+	if err == nil {
+		keyAuth := token + "." + thumbprint
+		ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+		switch typ {
+		case "http-01":
+			err = s.validateHTTP01(ctx, domain, token, keyAuth)
+		case "dns-01":
+			err = s.validateDNS01(ctx, domain, keyAuth)
+		default:
+			err = fmt.Errorf("unsupported challenge type %s", typ)
+		}
+		cancel()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		chall.status = "invalid"
+		chall.err = &acmeProblem{Type: acmeErrorPrefix + "incorrectResponse", Detail: err.Error(), Status: http.StatusForbidden}
+	} else {
+		chall.status = "valid"
+	}
+	// another challenge of the same authz may have finished first, its
+	// result stands
+	if authz.status != "pending" {
+		return
+	}
+	authz.status = chall.status
+	s.updateOrder(s.orders[authz.order])
+}
+
+// updateOrder moves an order to ready or invalid once its authzs are final.
+// Must be called with s.mu held.
+func (s *ACMEServer) updateOrder(order *serverOrder) {
+	if order == nil || order.status != "pending" {
+		return
+	}
+	ready := true
+	for _, id := range order.authzs {
+		switch s.authzs[id].status {
+		case "invalid":
+			order.status = "invalid"
+			order.err = &acmeProblem{Type: acmeErrorPrefix + "unauthorized", Detail: "authorization for " + s.authzs[id].identifier.Value + " failed"}
+			return
+		case "valid":
+		default:
+			ready = false
+		}
+	}
+	if ready {
+		order.status = "ready"
+	}
+}
+
+// https://tools.ietf.org/html/rfc8555#section-8.3
+func (s *ACMEServer) validateHTTP01(ctx context.Context, domain string, token string, keyAuth string) error {
+	host := domain
+	if s.conf.HTTPPort != 80 {
+		host = net.JoinHostPort(domain, strconv.Itoa(s.conf.HTTPPort))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+challengeHTTPRoute+token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http-01: unexpected status %d from %s", resp.StatusCode, domain)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return fmt.Errorf("http-01: key authorization mismatch for %s", domain)
+	}
+	return nil
+}
+
+// https://tools.ietf.org/html/rfc8555#section-8.4
+func (s *ACMEServer) validateDNS01(ctx context.Context, domain string, keyAuth string) error {
+	digest := sha256.Sum256([]byte(keyAuth))
+	want := base64.RawURLEncoding.EncodeToString(digest[:])
+	records, err := s.conf.Resolver.LookupTXT(ctx, "_acme-challenge."+domain)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("dns-01: no matching TXT record for %s", domain)
+}
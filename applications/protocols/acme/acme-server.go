@@ -0,0 +1,782 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 8555 server that signs certificates for internal hostnames
+// from a local (intermediate) CA. State is kept in memory only.
+// https://tools.ietf.org/html/rfc8555
+
+type ACMEServerConfig struct {
+	BaseURL        string              // externally reachable prefix, e.g. https://acme.internal:8443
+	CACert         *x509.Certificate   // issuing CA
+	CAKey          crypto.Signer       // private key of CACert
+	CAChain        []*x509.Certificate // certificates above CACert, appended to issued chains
+	CertValidity   time.Duration       // default 90 days
+	AllowedDomains []string            // accepted domain suffixes, empty allows any
+	// Non-empty ExternalAccountBinding makes EAB mandatory for new accounts,
+	// each binding registers a single account.
+	ExternalAccountBinding []ACMEExternalBinding
+	HTTPPort               int           // port used for http-01 validation, default 80
+	Resolver               *net.Resolver // used for dns-01 validation, default net.DefaultResolver
+}
+
+type ACMEServer struct {
+	conf ACMEServerConfig
+	mux  *http.ServeMux
+
+	mu       sync.Mutex
+	nonces   map[string]time.Time
+	accounts map[string]*serverAccount // by account id
+	keys     map[string]string         // key thumbprint -> account id
+	orders   map[string]*serverOrder
+	authzs   map[string]*serverAuthz
+	challs   map[string]*serverChall
+	certs    map[string][]byte // PEM chains by id
+}
+
+type serverAccount struct {
+	id      string
+	key     crypto.PublicKey
+	status  string
+	contact []string
+	eabKid  string
+}
+
+type serverOrder struct {
+	id          string
+	account     string
+	status      string
+	expires     time.Time
+	identifiers []AcmeOrderIdentifier
+	authzs      []string
+	cert        string
+	err         *acmeProblem
+}
+
+type serverAuthz struct {
+	id         string
+	order      string
+	identifier AcmeOrderIdentifier
+	wildcard   bool
+	status     string
+	expires    time.Time
+	challs     []string
+}
+
+type serverChall struct {
+	id     string
+	authz  string
+	typ    string
+	token  string
+	status string
+	err    *acmeProblem
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+// serverRequest is a verified JWS POST.
+type serverRequest struct {
+	payload []byte
+	key     crypto.PublicKey
+	account *serverAccount
+}
+
+const (
+	acmeErrorPrefix    = "urn:ietf:params:acme:error:"
+	orderLifetime      = 7 * 24 * time.Hour
+	nonceLifetime      = time.Hour
+	defaultCertValid   = 90 * 24 * time.Hour
+	challengeHTTPRoute = "/.well-known/acme-challenge/"
+)
+
+func NewACMEServer(conf ACMEServerConfig) (*ACMEServer, error) {
+	if conf.CACert == nil || conf.CAKey == nil {
+		return nil, errors.New("acme: server needs a CA certificate and key")
+	}
+	if conf.BaseURL == "" {
+		return nil, errors.New("acme: server needs a BaseURL")
+	}
+	conf.BaseURL = strings.TrimSuffix(conf.BaseURL, "/")
+	if conf.CertValidity <= 0 {
+		conf.CertValidity = defaultCertValid
+	}
+	if conf.HTTPPort == 0 {
+		conf.HTTPPort = 80
+	}
+	if conf.Resolver == nil {
+		conf.Resolver = net.DefaultResolver
+	}
+	s := &ACMEServer{
+		conf:     conf,
+		mux:      http.NewServeMux(),
+		nonces:   map[string]time.Time{},
+		accounts: map[string]*serverAccount{},
+		keys:     map[string]string{},
+		orders:   map[string]*serverOrder{},
+		authzs:   map[string]*serverAuthz{},
+		challs:   map[string]*serverChall{},
+		certs:    map[string][]byte{},
+	}
+	s.mux.HandleFunc("/directory", s.handleDirectory)
+	s.mux.HandleFunc("/new-nonce", s.handleNewNonce)
+	s.mux.HandleFunc("/new-account", s.handleNewAccount)
+	s.mux.HandleFunc("/new-order", s.handleNewOrder)
+	s.mux.HandleFunc("/account/", s.handleAccount)
+	s.mux.HandleFunc("/order/", s.handleOrder)
+	s.mux.HandleFunc("/authz/", s.handleAuthz)
+	s.mux.HandleFunc("/chall/", s.handleChall)
+	s.mux.HandleFunc("/finalize/", s.handleFinalize)
+	s.mux.HandleFunc("/cert/", s.handleCert)
+	s.mux.HandleFunc("/revoke-cert", s.handleUnsupported)
+	s.mux.HandleFunc("/key-change", s.handleUnsupported)
+	return s, nil
+}
+
+func (s *ACMEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *ACMEServer) url(path string) string {
+	return s.conf.BaseURL + path
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// response helpers
+
+func (s *ACMEServer) newNonce() string {
+	nonce := randomID()
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, t := range s.nonces {
+		if now.Sub(t) > nonceLifetime {
+			delete(s.nonces, n)
+		}
+	}
+	s.nonces[nonce] = now
+	return nonce
+}
+
+func (s *ACMEServer) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.nonces[nonce]
+	delete(s.nonces, nonce)
+	return ok && time.Since(t) <= nonceLifetime
+}
+
+func (s *ACMEServer) writeHeaders(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="index"`, s.url("/directory")))
+}
+
+func (s *ACMEServer) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	s.writeHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *ACMEServer) writeProblem(w http.ResponseWriter, status int, typ string, detail string) {
+	s.writeHeaders(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&acmeProblem{Type: acmeErrorPrefix + typ, Detail: detail, Status: status})
+}
+
+// parseRequest reads and verifies the JWS body of a POST request.
+// newAccount requests must carry a jwk, all other requests a kid.
+func (s *ACMEServer) parseRequest(w http.ResponseWriter, r *http.Request, newAccount bool) (*serverRequest, bool) {
+	if r.Method != http.MethodPost {
+		s.writeProblem(w, http.StatusMethodNotAllowed, "malformed", "method not allowed")
+		return nil, false
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		s.writeProblem(w, http.StatusUnsupportedMediaType, "malformed", "expected application/jose+json")
+		return nil, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return nil, false
+	}
+	var jws jwsFlattened
+	if err := json.Unmarshal(body, &jws); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "request is not a flattened JWS")
+		return nil, false
+	}
+	headBytes, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid protected header encoding")
+		return nil, false
+	}
+	var head jwsProtectedHeader
+	if err := json.Unmarshal(headBytes, &head); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid protected header")
+		return nil, false
+	}
+	if !s.useNonce(head.Nonce) {
+		s.writeProblem(w, http.StatusBadRequest, "badNonce", "invalid or reused nonce")
+		return nil, false
+	}
+	if head.Url != s.url(r.URL.Path) {
+		s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "url header does not match request")
+		return nil, false
+	}
+
+	req := &serverRequest{}
+	switch {
+	case newAccount && len(head.Jwk) > 0 && head.Kid == "":
+		req.key, err = jwkDecode(head.Jwk)
+		if err != nil {
+			s.writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+			return nil, false
+		}
+	case !newAccount && len(head.Jwk) == 0 && head.Kid != "":
+		// status is written by handleAccount, read it under the lock
+		var status string
+		s.mu.Lock()
+		account := s.accounts[strings.TrimPrefix(head.Kid, s.url("/account/"))]
+		if account != nil {
+			status = account.status
+			req.key = account.key
+		}
+		s.mu.Unlock()
+		if account == nil || !strings.HasPrefix(head.Kid, s.url("/account/")) {
+			s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+			return nil, false
+		}
+		if status != "valid" {
+			s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "account is "+status)
+			return nil, false
+		}
+		req.account = account
+	default:
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "exactly one of jwk and kid is required")
+		return nil, false
+	}
+	if err := jwsVerify(&jws, head.Alg, req.key); err != nil {
+		if errors.Is(err, errBadSignatureAlgorithm) {
+			s.writeProblem(w, http.StatusBadRequest, "badSignatureAlgorithm", fmt.Sprintf("algorithm %q is not supported", head.Alg))
+		} else {
+			s.writeProblem(w, http.StatusUnauthorized, "malformed", err.Error())
+		}
+		return nil, false
+	}
+	req.payload, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid payload encoding")
+		return nil, false
+	}
+	return req, true
+}
+
+// handlers
+
+func (s *ACMEServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	dir := AcmeDirectory{
+		NewNonce:   s.url("/new-nonce"),
+		NewAccount: s.url("/new-account"),
+		NewOrder:   s.url("/new-order"),
+		RevokeCert: s.url("/revoke-cert"),
+		KeyChange:  s.url("/key-change"),
+	}
+	dir.Meta.ExternalAccountRequired = len(s.conf.ExternalAccountBinding) > 0
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&dir)
+}
+
+func (s *ACMEServer) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	s.writeHeaders(w)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ACMEServer) handleUnsupported(w http.ResponseWriter, r *http.Request) {
+	s.writeProblem(w, http.StatusNotImplemented, "malformed", "not supported by this server")
+}
+
+// accountJSON must be called with s.mu held.
+func (s *ACMEServer) accountJSON(account *serverAccount) interface{} {
+	return struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact,omitempty"`
+		Orders  string   `json:"orders"`
+	}{account.status, account.contact, s.url("/account/" + account.id + "/orders")}
+}
+
+func (s *ACMEServer) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, true)
+	if !ok {
+		return
+	}
+	var payload struct {
+		AcmeNewAccountPayload
+		OnlyReturnExisting bool `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	thumbprint, err := JWKThumbprint(req.key)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badPublicKey", err.Error())
+		return
+	}
+	s.mu.Lock()
+	existing := s.accounts[s.keys[thumbprint]]
+	var body interface{}
+	if existing != nil {
+		body = s.accountJSON(existing)
+	}
+	s.mu.Unlock()
+	if existing != nil {
+		w.Header().Set("Location", s.url("/account/"+existing.id))
+		s.writeJSON(w, http.StatusOK, body)
+		return
+	}
+	if payload.OnlyReturnExisting {
+		s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "no account for this key")
+		return
+	}
+	if !payload.TermsOfServiceAgreed {
+		s.writeProblem(w, http.StatusBadRequest, "userActionRequired", "terms of service must be agreed")
+		return
+	}
+	account := &serverAccount{id: randomID(), key: req.key, status: "valid", contact: payload.Contact}
+	if len(s.conf.ExternalAccountBinding) > 0 {
+		if len(payload.ExternalAccountBinding) == 0 {
+			s.writeProblem(w, http.StatusBadRequest, "externalAccountRequired", "externalAccountBinding is required")
+			return
+		}
+		kid, err := verifyExternalAccountBinding(payload.ExternalAccountBinding, s.conf.ExternalAccountBinding, req.key, s.url("/new-account"))
+		if err != nil {
+			s.writeProblem(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+		account.eabKid = kid
+	}
+	s.mu.Lock()
+	if account.eabKid != "" {
+		for _, other := range s.accounts {
+			if other.eabKid == account.eabKid {
+				s.mu.Unlock()
+				s.writeProblem(w, http.StatusUnauthorized, "unauthorized", "externalAccountBinding key is already bound to an account")
+				return
+			}
+		}
+	}
+	s.accounts[account.id] = account
+	s.keys[thumbprint] = account.id
+	body = s.accountJSON(account)
+	s.mu.Unlock()
+	w.Header().Set("Location", s.url("/account/"+account.id))
+	s.writeJSON(w, http.StatusCreated, body)
+}
+
+func (s *ACMEServer) handleAccount(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/account/")
+	if id, found := strings.CutSuffix(path, "/orders"); found {
+		if id != req.account.id {
+			s.writeProblem(w, http.StatusForbidden, "unauthorized", "not your account")
+			return
+		}
+		s.mu.Lock()
+		list := []string{}
+		for _, order := range s.orders {
+			if order.account == id {
+				list = append(list, s.url("/order/"+order.id))
+			}
+		}
+		s.mu.Unlock()
+		s.writeJSON(w, http.StatusOK, map[string][]string{"orders": list})
+		return
+	}
+	if path != req.account.id {
+		s.writeProblem(w, http.StatusForbidden, "unauthorized", "not your account")
+		return
+	}
+	var update struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact"`
+	}
+	if len(req.payload) > 0 {
+		if err := json.Unmarshal(req.payload, &update); err != nil {
+			s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+			return
+		}
+	}
+	s.mu.Lock()
+	if update.Contact != nil {
+		req.account.contact = update.Contact
+	}
+	if update.Status == "deactivated" {
+		req.account.status = "deactivated"
+	}
+	body := s.accountJSON(req.account)
+	s.mu.Unlock()
+	s.writeJSON(w, http.StatusOK, body)
+}
+
+func (s *ACMEServer) domainAllowed(domain string) bool {
+	if len(s.conf.AllowedDomains) == 0 {
+		return true
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+	for _, allowed := range s.conf.AllowedDomains {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ACMEServer) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	var payload AcmeNewOrderPayload
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+	for _, ident := range payload.Identifiers {
+		if ident.Type != "dns" {
+			s.writeProblem(w, http.StatusBadRequest, "unsupportedIdentifier", "only dns identifiers are supported")
+			return
+		}
+		if !s.domainAllowed(ident.Value) {
+			s.writeProblem(w, http.StatusBadRequest, "rejectedIdentifier", ident.Value+" is not allowed by policy")
+			return
+		}
+	}
+	order := &serverOrder{
+		id:          randomID(),
+		account:     req.account.id,
+		status:      "pending",
+		expires:     time.Now().Add(orderLifetime),
+		identifiers: payload.Identifiers,
+	}
+	s.mu.Lock()
+	for _, ident := range payload.Identifiers {
+		authz := &serverAuthz{
+			id:         randomID(),
+			order:      order.id,
+			identifier: AcmeOrderIdentifier{Type: "dns", Value: strings.TrimPrefix(ident.Value, "*.")},
+			wildcard:   strings.HasPrefix(ident.Value, "*."),
+			status:     "pending",
+			expires:    order.expires,
+		}
+		types := []string{"http-01", "dns-01"}
+		if authz.wildcard {
+			types = []string{"dns-01"}
+		}
+		for _, typ := range types {
+			chall := &serverChall{id: randomID(), authz: authz.id, typ: typ, token: randomID(), status: "pending"}
+			s.challs[chall.id] = chall
+			authz.challs = append(authz.challs, chall.id)
+		}
+		s.authzs[authz.id] = authz
+		order.authzs = append(order.authzs, authz.id)
+	}
+	s.orders[order.id] = order
+	body := s.orderJSON(order)
+	s.mu.Unlock()
+	w.Header().Set("Location", s.url("/order/"+order.id))
+	s.writeJSON(w, http.StatusCreated, body)
+}
+
+// orderJSON must be called with s.mu held.
+func (s *ACMEServer) orderJSON(order *serverOrder) interface{} {
+	res := AcmeFinalizeRes{
+		Status:      order.status,
+		Expires:     order.expires.UTC().Format(time.RFC3339),
+		Identifiers: order.identifiers,
+		Finalize:    s.url("/finalize/" + order.id),
+	}
+	for _, id := range order.authzs {
+		res.Authorizations = append(res.Authorizations, s.url("/authz/"+id))
+	}
+	if order.cert != "" {
+		res.Certificate = s.url("/cert/" + order.cert)
+	}
+	return struct {
+		AcmeFinalizeRes
+		Error *acmeProblem `json:"error,omitempty"`
+	}{res, order.err}
+}
+
+// challJSON must be called with s.mu held.
+func (s *ACMEServer) challJSON(chall *serverChall) interface{} {
+	return struct {
+		AcmeChallenge
+		Error *acmeProblem `json:"error,omitempty"`
+	}{AcmeChallenge{Type: chall.typ, Status: chall.status, Url: s.url("/chall/" + chall.id), Token: chall.token}, chall.err}
+}
+
+// authzJSON must be called with s.mu held.
+func (s *ACMEServer) authzJSON(authz *serverAuthz) interface{} {
+	res := AcmeAuthz{
+		Identifier: authz.identifier,
+		Status:     authz.status,
+		Expires:    authz.expires.UTC().Format(time.RFC3339),
+		Wildcard:   authz.wildcard,
+	}
+	for _, id := range authz.challs {
+		chall := s.challs[id]
+		res.Challenges = append(res.Challenges, AcmeChallenge{Type: chall.typ, Status: chall.status, Url: s.url("/chall/" + chall.id), Token: chall.token})
+	}
+	return res
+}
+
+// ownedOrder returns the order only if it belongs to account, must be called with s.mu held.
+func (s *ACMEServer) ownedOrder(orderID string, account *serverAccount) *serverOrder {
+	order := s.orders[orderID]
+	if order == nil || order.account != account.id {
+		return nil
+	}
+	return order
+}
+
+func (s *ACMEServer) handleOrder(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	order := s.ownedOrder(strings.TrimPrefix(r.URL.Path, "/order/"), req.account)
+	if order == nil {
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	body := s.orderJSON(order)
+	s.mu.Unlock()
+	s.writeJSON(w, http.StatusOK, body)
+}
+
+func (s *ACMEServer) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	authz := s.authzs[strings.TrimPrefix(r.URL.Path, "/authz/")]
+	if authz == nil || s.ownedOrder(authz.order, req.account) == nil {
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+	body := s.authzJSON(authz)
+	s.mu.Unlock()
+	s.writeJSON(w, http.StatusOK, body)
+}
+
+func (s *ACMEServer) handleChall(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	chall := s.challs[strings.TrimPrefix(r.URL.Path, "/chall/")]
+	var authz *serverAuthz
+	if chall != nil {
+		authz = s.authzs[chall.authz]
+	}
+	if authz == nil || s.ownedOrder(authz.order, req.account) == nil {
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+	// An empty JSON object starts validation, an empty payload is a POST-as-GET.
+	if len(req.payload) > 0 && chall.status == "pending" && authz.status == "pending" {
+		chall.status = "processing"
+		go s.validate(chall.id, req.account.key)
+	}
+	body := s.challJSON(chall)
+	s.mu.Unlock()
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="up"`, s.url("/authz/"+authz.id)))
+	s.writeJSON(w, http.StatusOK, body)
+}
+
+func (s *ACMEServer) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Csr string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	orderID := strings.TrimPrefix(r.URL.Path, "/finalize/")
+	s.mu.Lock()
+	order := s.ownedOrder(orderID, req.account)
+	if order == nil {
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if order.status != "ready" {
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusForbidden, "orderNotReady", "order is "+order.status)
+		return
+	}
+	order.status = "processing"
+	identifiers := order.identifiers
+	s.mu.Unlock()
+
+	chain, err := s.issue(payload.Csr, identifiers)
+	s.mu.Lock()
+	if err != nil {
+		order.status = "ready"
+		s.mu.Unlock()
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	certID := randomID()
+	s.certs[certID] = chain
+	order.cert = certID
+	order.status = "valid"
+	body := s.orderJSON(order)
+	s.mu.Unlock()
+	w.Header().Set("Location", s.url("/order/"+order.id))
+	s.writeJSON(w, http.StatusOK, body)
+}
+
+func (s *ACMEServer) handleCert(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRequest(w, r, false)
+	if !ok {
+		return
+	}
+	certID := strings.TrimPrefix(r.URL.Path, "/cert/")
+	s.mu.Lock()
+	var chain []byte
+	for _, order := range s.orders {
+		if order.cert == certID && order.account == req.account.id {
+			chain = s.certs[certID]
+			break
+		}
+	}
+	s.mu.Unlock()
+	if chain == nil {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+	s.writeHeaders(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+// issue checks the CSR against the order identifiers and signs it with the CA.
+func (s *ACMEServer) issue(csrB64 string, identifiers []AcmeOrderIdentifier) ([]byte, error) {
+	der, err := base64.RawURLEncoding.DecodeString(csrB64)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	want := map[string]bool{}
+	for _, ident := range identifiers {
+		want[strings.ToLower(ident.Value)] = true
+	}
+	names := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		names[strings.ToLower(name)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" && !names[strings.ToLower(cn)] {
+		names[strings.ToLower(cn)] = true
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, errors.New("CSR may only contain DNS names")
+	}
+	if len(names) != len(want) {
+		return nil, errors.New("CSR names do not match order identifiers")
+	}
+	dnsNames := make([]string, 0, len(names))
+	for name := range names {
+		if !want[name] {
+			return nil, fmt.Errorf("CSR name %s is not part of the order", name)
+		}
+		dnsNames = append(dnsNames, name)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(s.conf.CertValidity),
+		DNSNames:              dnsNames,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	template.Subject.CommonName = identifiers[0].Value
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		// RSA keys are still used for key encipherment by TLS 1.2 clients.
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, s.conf.CACert, csr.PublicKey, s.conf.CAKey)
+	if err != nil {
+		return nil, err
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.conf.CACert.Raw})...)
+	for _, cert := range s.conf.CAChain {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return chain, nil
+}
@@ -0,0 +1,468 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mygolibs/pki"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// txtServer is a DNS stand-in answering TXT queries from records.
+type txtServer struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]string // by fqdn
+}
+
+func newTXTServer(t *testing.T) *txtServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &txtServer{conn: conn, records: map[string][]string{}}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *txtServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+			continue
+		}
+		q := msg.Questions[0]
+		msg.Header.Response = true
+		msg.Header.Authoritative = true
+		msg.Answers = nil
+		s.mu.Lock()
+		values := s.records[strings.ToLower(q.Name.String())]
+		s.mu.Unlock()
+		if q.Type == dnsmessage.TypeTXT {
+			for _, v := range values {
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 1},
+					Body:   &dnsmessage.TXTResource{TXT: []string{v}},
+				})
+			}
+		}
+		if len(values) == 0 {
+			msg.Header.RCode = dnsmessage.RCodeNameError
+		}
+		out, err := msg.Pack()
+		if err != nil {
+			continue
+		}
+		s.conn.WriteTo(out, addr)
+	}
+}
+
+func (s *txtServer) Present(fqdn string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[strings.ToLower(fqdn)] = append(s.records[strings.ToLower(fqdn)], value)
+	return nil
+}
+
+func (s *txtServer) CleanUp(fqdn string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, strings.ToLower(fqdn))
+	return nil
+}
+
+func (s *txtServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+// newTestACMEServer starts an ACMEServer for conf, filling in BaseURL and
+// a fresh root CA.
+func newTestACMEServer(t *testing.T, conf ACMEServerConfig) (*ACMEServer, *x509.Certificate, AcmeDirectory) {
+	root, err := pki.NewRootCA(pki.CertRequest{CommonName: "ACME Test Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *ACMEServer
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { srv.ServeHTTP(w, r) }))
+	t.Cleanup(ts.Close)
+	conf.BaseURL, conf.CACert, conf.CAKey = ts.URL, root.Cert, root.Key
+	srv, err = NewACMEServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir AcmeDirectory
+	if _, err := ACMEGetRequest(ts.URL+"/directory", &dir); err != nil {
+		t.Fatal(err)
+	}
+	return srv, root.Cert, dir
+}
+
+func newTestAccount(t *testing.T, dir AcmeDirectory) ACMERequestOption {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := ACMERequestOption{Account: ACMEAccount{PrivKey: key}, Dirs: dir}
+	option.Payload = AcmeNewAccountPayload{TermsOfServiceAgreed: true, Contact: []string{"mailto:test@example.com"}}
+	resp, err := ACMEPostRequest(dir.NewAccount, option, &AcmeNewAccount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("new account: %d %s", resp.StatusCode(), resp.Body())
+	}
+	option.Account.Kid = resp.Header().Get("Location")
+	return option
+}
+
+func TestACMEServerDNS01(t *testing.T) {
+	dns := newTXTServer(t)
+	_, _, dir := newTestACMEServer(t, ACMEServerConfig{Resolver: dns.resolver()})
+	option := newTestAccount(t, dir)
+
+	// only newAccount may be signed with a jwk
+	option.Payload = AcmeNewOrderPayload{Identifiers: []AcmeOrderIdentifier{{Type: "dns", Value: "*.svc.internal"}}}
+	resp, err := ACMEPostRequest(dir.NewOrder, option, &AcmeFinalizeRes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("jwk signed new order: got %d, want 400", resp.StatusCode())
+	}
+
+	order := &AcmeFinalizeRes{}
+	resp, err = ACMEPostRequestWithKid(dir.NewOrder, option, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusCreated || len(order.Authorizations) != 1 {
+		t.Fatalf("new order: %d %s", resp.StatusCode(), resp.Body())
+	}
+	orderURL := resp.Header().Get("Location")
+
+	option.Payload = ""
+	authz := &AcmeAuthz{}
+	if resp, err = ACMEPostRequestWithKid(order.Authorizations[0], option, authz); err != nil || resp.StatusCode() != http.StatusOK {
+		t.Fatalf("authz: %v %s", err, resp.Body())
+	}
+	if !authz.Wildcard || len(authz.Challenges) != 1 {
+		t.Fatalf("wildcard authz should only offer dns-01: %+v", authz)
+	}
+
+	propagation := DNSPropagationOption{Timeout: 5 * time.Second, Interval: 50 * time.Millisecond, Nameservers: []string{dns.conn.LocalAddr().String()}}
+	if _, err := SolveDNS01Challenge(dns, *authz, option, propagation); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for order.Status != "ready" {
+		if order.Status == "invalid" || time.Now().After(deadline) {
+			t.Fatalf("order status %q", order.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+		option.Payload = ""
+		if _, err := ACMEPostRequestWithKid(orderURL, option, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestACMEServerDeactivatedAccount(t *testing.T) {
+	_, _, dir := newTestACMEServer(t, ACMEServerConfig{})
+	option := newTestAccount(t, dir)
+
+	// concurrent requests while the account is deactivated, for -race
+	get := option
+	get.Payload = ""
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ACMEPostRequestWithKid(get.Account.Kid, get, &struct{}{})
+		}()
+	}
+	option.Payload = map[string]string{"status": "deactivated"}
+	if resp, err := ACMEPostRequestWithKid(option.Account.Kid, option, &struct{}{}); err != nil || resp.StatusCode() != http.StatusOK {
+		t.Fatalf("deactivate: %v", err)
+	}
+	wg.Wait()
+
+	option.Payload = AcmeNewOrderPayload{Identifiers: []AcmeOrderIdentifier{{Type: "dns", Value: "a.internal"}}}
+	resp, err := ACMEPostRequestWithKid(dir.NewOrder, option, &AcmeFinalizeRes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusUnauthorized {
+		t.Fatalf("deactivated account: got %d, want 401", resp.StatusCode())
+	}
+}
+
+func TestWaitForDNSPropagationNoNameservers(t *testing.T) {
+	err := WaitForDNSPropagation("_acme-challenge.does-not-exist.invalid.", "x", DNSPropagationOption{Timeout: time.Second})
+	if !errors.Is(err, ErrDNSNameservers) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// postAsGet fetches url with a kid signed POST-as-GET and returns the raw
+// response, for bodies that are not JSON.
+func postAsGet(t *testing.T, url string, option ACMERequestOption) *http.Response {
+	nonce, err := AcmeNewNonce(option.Dirs.NewNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := JwsEncodeJSONWithKid(nil, option.Account.PrivKey.(crypto.Signer), nonce, url, option.Account.Kid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// waitOrder polls orderURL until the order leaves pending.
+func waitOrder(t *testing.T, orderURL string, option ACMERequestOption) *AcmeFinalizeRes {
+	order := &AcmeFinalizeRes{}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		option.Payload = ""
+		if _, err := ACMEPostRequestWithKid(orderURL, option, order); err != nil {
+			t.Fatal(err)
+		}
+		if order.Status != "pending" || time.Now().After(deadline) {
+			return order
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestACMEServerHTTP01Finalize(t *testing.T) {
+	// http-01 responder, tokens are filled in once the authz is known
+	var keyAuths sync.Map
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyAuth, ok := keyAuths.Load(strings.TrimPrefix(r.URL.Path, challengeHTTPRoute))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, keyAuth.(string))
+	}))
+	t.Cleanup(web.Close)
+	_, port, _ := net.SplitHostPort(web.Listener.Addr().String())
+	httpPort, _ := strconv.Atoi(port)
+
+	srv, root, dir := newTestACMEServer(t, ACMEServerConfig{HTTPPort: httpPort})
+	option := newTestAccount(t, dir)
+
+	option.Payload = AcmeNewOrderPayload{Identifiers: []AcmeOrderIdentifier{{Type: "dns", Value: "localhost"}}}
+	order := &AcmeFinalizeRes{}
+	resp, err := ACMEPostRequestWithKid(dir.NewOrder, option, order)
+	if err != nil || resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("new order: %v %s", err, resp.Body())
+	}
+	orderURL := resp.Header().Get("Location")
+
+	// finalizing before the order is ready is refused
+	option.Payload = map[string]string{"csr": ""}
+	if resp, err := ACMEPostRequestWithKid(order.Finalize, option, &struct{}{}); err != nil || resp.StatusCode() != http.StatusForbidden {
+		t.Fatalf("early finalize: %v %d", err, resp.StatusCode())
+	}
+
+	option.Payload = ""
+	authz := &AcmeAuthz{}
+	if _, err := ACMEPostRequestWithKid(order.Authorizations[0], option, authz); err != nil {
+		t.Fatal(err)
+	}
+	var httpChall, dnsChall AcmeChallenge
+	for _, chall := range authz.Challenges {
+		switch chall.Type {
+		case "http-01":
+			httpChall = chall
+		case "dns-01":
+			dnsChall = chall
+		}
+	}
+	if httpChall.Url == "" || dnsChall.Url == "" {
+		t.Fatalf("want http-01 and dns-01 challenges: %+v", authz.Challenges)
+	}
+	thumbprint, err := JWKThumbprint(option.Account.PrivKey.(crypto.Signer).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyAuths.Store(httpChall.Token, httpChall.Token+"."+thumbprint)
+
+	option.Payload = struct{}{}
+	if _, err := ACMEPostRequestWithKid(httpChall.Url, option, &AcmeChall{}); err != nil {
+		t.Fatal(err)
+	}
+	if order = waitOrder(t, orderURL, option); order.Status != "ready" {
+		t.Fatalf("order status %q", order.Status)
+	}
+
+	// a failing dns-01 run finishing after http-01 leaves the authz valid
+	srv.mu.Lock()
+	dnsID := strings.TrimPrefix(dnsChall.Url, srv.url("/chall/"))
+	srv.challs[dnsID].status = "processing"
+	srv.mu.Unlock()
+	srv.validate(dnsID, option.Account.PrivKey.(crypto.Signer).Public())
+	option.Payload = ""
+	if _, err := ACMEPostRequestWithKid(order.Authorizations[0], option, authz); err != nil {
+		t.Fatal(err)
+	}
+	if authz.Status != "valid" {
+		t.Fatalf("authz status %q after a late failed challenge", authz.Status)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := func(names ...string) string {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: names}, certKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(der)
+	}
+	option.Payload = map[string]string{"csr": csr("localhost", "other.internal")}
+	if resp, err := ACMEPostRequestWithKid(order.Finalize, option, &struct{}{}); err != nil || resp.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("CSR with extra names: %v %d", err, resp.StatusCode())
+	}
+	option.Payload = map[string]string{"csr": csr("localhost")}
+	if resp, err := ACMEPostRequestWithKid(order.Finalize, option, order); err != nil || resp.StatusCode() != http.StatusOK {
+		t.Fatalf("finalize: %v %s", err, resp.Body())
+	}
+	if order.Status != "valid" || order.Certificate == "" {
+		t.Fatalf("finalized order: %+v", order)
+	}
+
+	cert := postAsGet(t, order.Certificate, option)
+	if ct := cert.Header.Get("Content-Type"); cert.StatusCode != http.StatusOK || ct != "application/pem-certificate-chain" {
+		t.Fatalf("certificate download: %d %s", cert.StatusCode, ct)
+	}
+	chainPEM, err := io.ReadAll(cert.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := pki.ParseCertificatesPEM(chainPEM)
+	if err != nil || len(chain) != 2 {
+		t.Fatalf("chain: %v, %d certificates", err, len(chain))
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pki.CertPool(root)}); err != nil {
+		t.Fatal(err)
+	}
+	if !chain[0].PublicKey.(*ecdsa.PublicKey).Equal(&certKey.PublicKey) {
+		t.Fatal("certificate is not for the CSR key")
+	}
+
+	// another account cannot download it
+	other := newTestAccount(t, dir)
+	if resp := postAsGet(t, order.Certificate, other); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("certificate of another account: got %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestACMEServerExternalAccountBinding(t *testing.T) {
+	macKey := make([]byte, 32)
+	rand.Read(macKey)
+	eab := ACMEExternalBinding{Kid: "kid-1", HmacKey: base64.RawURLEncoding.EncodeToString(macKey)}
+	_, _, dir := newTestACMEServer(t, ACMEServerConfig{ExternalAccountBinding: []ACMEExternalBinding{eab}})
+	if !dir.Meta.ExternalAccountRequired {
+		t.Fatal("directory does not require EAB")
+	}
+
+	register := func(key crypto.Signer, binding *ACMEExternalBinding) int {
+		option := ACMERequestOption{Account: ACMEAccount{PrivKey: key}, Dirs: dir}
+		payload := AcmeNewAccountPayload{TermsOfServiceAgreed: true}
+		if binding != nil {
+			jws, err := ExternalAccountBindingJWS(*binding, key.Public(), dir.NewAccount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload.ExternalAccountBinding = jws
+		}
+		option.Payload = payload
+		resp, err := ACMEPostRequest(dir.NewAccount, option, &AcmeNewAccount{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode()
+	}
+	newKey := func() crypto.Signer {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	first := newKey()
+	if code := register(first, nil); code != http.StatusBadRequest {
+		t.Fatalf("without EAB: got %d, want 400", code)
+	}
+	wrong := ACMEExternalBinding{Kid: eab.Kid, HmacKey: base64.RawURLEncoding.EncodeToString(make([]byte, 32))}
+	if code := register(first, &wrong); code != http.StatusUnauthorized {
+		t.Fatalf("wrong MAC key: got %d, want 401", code)
+	}
+	if code := register(first, &eab); code != http.StatusCreated {
+		t.Fatalf("with EAB: got %d, want 201", code)
+	}
+	// the same key finds its account again
+	if code := register(first, &eab); code != http.StatusOK {
+		t.Fatalf("existing account: got %d, want 200", code)
+	}
+	if code := register(newKey(), &eab); code != http.StatusUnauthorized {
+		t.Fatalf("reused EAB: got %d, want 401", code)
+	}
+}
+
+func TestACMEServerWeakRSAKey(t *testing.T) {
+	_, _, dir := newTestACMEServer(t, ACMEServerConfig{})
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := ACMERequestOption{Account: ACMEAccount{PrivKey: key}, Dirs: dir}
+	option.Payload = AcmeNewAccountPayload{TermsOfServiceAgreed: true}
+	resp, err := ACMEPostRequest(dir.NewAccount, option, &AcmeNewAccount{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != http.StatusBadRequest || !strings.Contains(string(resp.Body()), "badPublicKey") {
+		t.Fatalf("1024 bit RSA account: %d %s", resp.StatusCode(), resp.Body())
+	}
+}
@@ -0,0 +1,31 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"mygolibs/encrypt"
+	"time"
+
+	"filippo.io/age"
+)
+
+type MetricsExport struct {
+	Time    time.Time
+	Static  *StaticMetrics
+	Dynamic *DynamicMetrics
+}
+
+// ExportMetrics writes a snapshot of the static and dynamic metrics as JSON
+// encrypted to recipients, so it can be shipped off the host and read back
+// with "age -d".
+func ExportMetrics(w io.Writer, recipients ...age.Recipient) error {
+	enc, err := encrypt.AgeEncryptWriter(w, false, recipients...)
+	if err != nil {
+		return err
+	}
+	export := MetricsExport{Time: time.Now().UTC(), Static: StaticMetricsData(), Dynamic: DynamicMetricsData()}
+	if err := json.NewEncoder(enc).Encode(export); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,226 @@
+package encrypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Streams are split into chunks sealed one by one, so files of any size
+// are encrypted in constant memory:
+//
+//	version 2 | alg | kid length | kid | nonce prefix | chunk | chunk | ...
+//
+// Each chunk holds aeadChunkSize bytes of plaintext, the last one may hold
+// less. The nonce of a chunk is the prefix, its 32 bit counter and a flag set
+// on the last chunk only, the STREAM construction of
+// https://eprint.iacr.org/2015/189.pdf, so chunks can neither be reordered
+// nor dropped, and a truncated stream fails to open.
+
+const aeadChunkSize = 64 << 10
+
+func aeadChunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+var errAEADStreamTooLong = errors.New("aead: stream too long")
+
+type aeadSealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// AEADSealWriter returns a writer encrypting everything written to it into w.
+// Close must be called to write the last chunk, it does not close w.
+func AEADSealWriter(w io.Writer, alg string, kid string, key []byte, aad []byte) (io.WriteCloser, error) {
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := aeadHeader(aeadStreamV1, alg, kid)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte(nil), header...), prefix...)); err != nil {
+		return nil, err
+	}
+	return &aeadSealWriter{
+		w:      w,
+		aead:   aead,
+		ad:     aeadAdditionalData(header, aad),
+		prefix: prefix,
+		buf:    make([]byte, 0, aeadChunkSize),
+	}, nil
+}
+
+func (s *aeadSealWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("aead: write after close")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data follows, the last
+		// chunk must be sealed as such by Close
+		if len(s.buf) == aeadChunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):aeadChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *aeadSealWriter) flush(last bool) error {
+	if !last && s.counter == 1<<32-1 {
+		return errAEADStreamTooLong
+	}
+	chunk := s.aead.Seal(nil, aeadChunkNonce(s.prefix, s.counter, last), s.buf, s.ad)
+	if _, err := s.w.Write(chunk); err != nil {
+		return err
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *aeadSealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+type aeadOpenReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+	err     error
+}
+
+// AEADOpenReader returns a reader decrypting a stream made by AEADSealWriter,
+// looking its key up by kid. Every chunk is authenticated before it is
+// returned, but a stream is only known to be complete once Read returns io.EOF.
+func AEADOpenReader(r io.Reader, aad []byte, keys AEADKeyFunc) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, aeadChunkSize+64)
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, ErrAEADEnvelope
+	}
+	kid := make([]byte, head[2])
+	if _, err := io.ReadFull(br, kid); err != nil {
+		return nil, ErrAEADEnvelope
+	}
+	header, alg, _, _, err := parseAEADHeader(append(head, kid...), aeadStreamV1)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys(string(kid))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, ErrAEADEnvelope
+	}
+	return &aeadOpenReader{
+		r:      br,
+		aead:   aead,
+		ad:     aeadAdditionalData(header, aad),
+		prefix: prefix,
+		chunk:  make([]byte, aeadChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (o *aeadOpenReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.err != nil {
+			return 0, o.err
+		}
+		if o.done {
+			return 0, io.EOF
+		}
+		o.err = o.next()
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+// next reads and opens one chunk. A chunk is the last one when it is short
+// or nothing follows it.
+func (o *aeadOpenReader) next() error {
+	n, err := io.ReadFull(o.r, o.chunk)
+	last := false
+	switch err {
+	case nil:
+		if _, err := o.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// the last chunk is never empty, it holds at least the tag
+		return ErrAEADOpen
+	default:
+		return err
+	}
+	if !last && o.counter == 1<<32-1 {
+		return errAEADStreamTooLong
+	}
+	plaintext, err := o.aead.Open(o.chunk[:0], aeadChunkNonce(o.prefix, o.counter, last), o.chunk[:n], o.ad)
+	if err != nil {
+		return ErrAEADOpen
+	}
+	o.counter++
+	o.buf = plaintext
+	o.done = last
+	return nil
+}
+
+// SealWriter is AEADSealWriter with the current key.
+func (k *AEADKeyring) SealWriter(w io.Writer, aad []byte) (io.WriteCloser, error) {
+	alg, kid, key, err := k.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	return AEADSealWriter(w, alg, kid, key, aad)
+}
+
+// OpenReader is AEADOpenReader with any key of the keyring.
+func (k *AEADKeyring) OpenReader(r io.Reader, aad []byte) (io.Reader, error) {
+	return AEADOpenReader(r, aad, k.Key)
+}
@@ -0,0 +1,317 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Sealed envelopes carry everything needed to open them except the key:
+//
+//	version (1 byte) | alg (1 byte) | kid length (1 byte) | kid | nonce | ciphertext and tag
+//
+// The header up to the kid is authenticated along with the caller's
+// additional data, so neither the algorithm nor the kid can be swapped.
+
+// AEAD algorithms, both take 32 byte keys.
+const (
+	AEADAES256GCM         = "aes-256-gcm"
+	AEADXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// AEADKeySize is the key length of every AEAD algorithm.
+const AEADKeySize = 32
+
+const (
+	aeadEnvelopeV1 byte = 1
+	aeadStreamV1   byte = 2
+)
+
+var aeadAlgIDs = map[string]byte{
+	AEADAES256GCM:         1,
+	AEADXChaCha20Poly1305: 2,
+}
+
+var (
+	// ErrAEADEnvelope is returned for data that is not an envelope made by this package.
+	ErrAEADEnvelope = errors.New("aead: malformed envelope")
+	// ErrAEADOpen is returned when the envelope was tampered with or the key is wrong.
+	ErrAEADOpen = errors.New("aead: message authentication failed")
+)
+
+// AEADKeyFunc resolves the key of a key id found in an envelope.
+type AEADKeyFunc func(kid string) ([]byte, error)
+
+func newAEAD(alg string, key []byte) (cipher.AEAD, error) {
+	if len(key) != AEADKeySize {
+		return nil, fmt.Errorf("aead: key must be %d bytes, got %d", AEADKeySize, len(key))
+	}
+	switch alg {
+	case AEADAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AEADXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("aead: unsupported algorithm %q", alg)
+}
+
+func aeadAlgName(id byte) (string, error) {
+	for name, v := range aeadAlgIDs {
+		if v == id {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: unknown algorithm %d", ErrAEADEnvelope, id)
+}
+
+// aeadHeader encodes version, algorithm and kid.
+func aeadHeader(version byte, alg string, kid string) ([]byte, error) {
+	id, ok := aeadAlgIDs[alg]
+	if !ok {
+		return nil, fmt.Errorf("aead: unsupported algorithm %q", alg)
+	}
+	if kid == "" || len(kid) > 255 {
+		return nil, fmt.Errorf("aead: key id must be 1 to 255 bytes")
+	}
+	return append([]byte{version, id, byte(len(kid))}, kid...), nil
+}
+
+// parseAEADHeader splits an envelope into header, algorithm, kid and the rest.
+func parseAEADHeader(data []byte, version byte) (header []byte, alg string, kid string, rest []byte, err error) {
+	if len(data) < 3 || data[0] != version {
+		return nil, "", "", nil, ErrAEADEnvelope
+	}
+	n := 3 + int(data[2])
+	if data[2] == 0 || len(data) < n {
+		return nil, "", "", nil, ErrAEADEnvelope
+	}
+	if alg, err = aeadAlgName(data[1]); err != nil {
+		return nil, "", "", nil, err
+	}
+	return data[:n], alg, string(data[3:n]), data[n:], nil
+}
+
+func aeadAdditionalData(header []byte, aad []byte) []byte {
+	return append(append([]byte(nil), header...), aad...)
+}
+
+// AEADSeal encrypts plaintext with key, labelled kid, under a random nonce.
+// aad is authenticated but not stored, the same value must be given to open.
+func AEADSeal(alg string, kid string, key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := aeadHeader(aeadEnvelopeV1, alg, kid)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), header...), nonce...)
+	return aead.Seal(out, nonce, plaintext, aeadAdditionalData(header, aad)), nil
+}
+
+// AEADOpen decrypts an envelope made by AEADSeal, looking its key up by kid.
+func AEADOpen(envelope []byte, aad []byte, keys AEADKeyFunc) ([]byte, error) {
+	header, alg, kid, rest, err := parseAEADHeader(envelope, aeadEnvelopeV1)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys(kid)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrAEADEnvelope
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aeadAdditionalData(header, aad))
+	if err != nil {
+		return nil, ErrAEADOpen
+	}
+	return plaintext, nil
+}
+
+// AEADKeyring holds every key that may still be needed to open envelopes and
+// seals with the current one, so keys can be rotated without re-encrypting
+// everything at once.
+type AEADKeyring struct {
+	Algorithm string // AEADXChaCha20Poly1305 by default
+
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+func NewAEADKeyring(alg string) *AEADKeyring {
+	return &AEADKeyring{Algorithm: alg, keys: make(map[string][]byte)}
+}
+
+// Add registers key under kid, the first key added becomes the current one.
+func (k *AEADKeyring) Add(kid string, key []byte) error {
+	if len(key) != AEADKeySize {
+		return fmt.Errorf("aead: key %q must be %d bytes, got %d", kid, AEADKeySize, len(key))
+	}
+	if kid == "" || len(kid) > 255 {
+		return fmt.Errorf("aead: key id must be 1 to 255 bytes")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string][]byte)
+	}
+	k.keys[kid] = append([]byte(nil), key...)
+	if k.current == "" {
+		k.current = kid
+	}
+	return nil
+}
+
+// AddDerived adds every valid key of purpose from a DerivedKeyring and makes
+// its current generation the one used for sealing.
+func (k *AEADKeyring) AddDerived(keyring *DerivedKeyring, purpose string) error {
+	keys, err := keyring.Verification(purpose)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := k.Add(key.ID, key.Key); err != nil {
+			return err
+		}
+	}
+	// Verification lists the current generation first
+	return k.SetCurrent(keys[0].ID)
+}
+
+// SetCurrent makes kid the key used for sealing.
+func (k *AEADKeyring) SetCurrent(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+	}
+	k.current = kid
+	return nil
+}
+
+// Remove drops a retired key, envelopes sealed with it can no longer be opened.
+func (k *AEADKeyring) Remove(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if kid == k.current {
+		return fmt.Errorf("aead: key %q is the current key", kid)
+	}
+	delete(k.keys, kid)
+	return nil
+}
+
+// KeyIDs returns the ids of all keys, sorted.
+func (k *AEADKeyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Key returns the key of kid, it is an AEADKeyFunc.
+func (k *AEADKeyring) Key(kid string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+func (k *AEADKeyring) currentKey() (string, string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current == "" {
+		return "", "", nil, fmt.Errorf("%w: keyring is empty", ErrKeyNotFound)
+	}
+	alg := k.Algorithm
+	if alg == "" {
+		alg = AEADXChaCha20Poly1305
+	}
+	return alg, k.current, k.keys[k.current], nil
+}
+
+// Seal encrypts plaintext with the current key.
+func (k *AEADKeyring) Seal(plaintext []byte, aad []byte) ([]byte, error) {
+	alg, kid, key, err := k.currentKey()
+	if err != nil {
+		return nil, err
+	}
+	return AEADSeal(alg, kid, key, plaintext, aad)
+}
+
+// Open decrypts an envelope sealed with any key of the keyring.
+func (k *AEADKeyring) Open(envelope []byte, aad []byte) ([]byte, error) {
+	return AEADOpen(envelope, aad, k.Key)
+}
+
+// NeedsRotation reports whether an envelope was sealed with a key other than
+// the current one, and should be opened and sealed again.
+func (k *AEADKeyring) NeedsRotation(envelope []byte) bool {
+	_, _, kid, _, err := parseAEADHeader(envelope, aeadEnvelopeV1)
+	if err != nil {
+		return true
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return kid != k.current
+}
+
+// aeadStringPrefix marks sealed values in config files.
+const aeadStringPrefix = "enc:"
+
+// SealString returns "enc:<base64url envelope>", safe to paste into YAML.
+func (k *AEADKeyring) SealString(plaintext string) (string, error) {
+	envelope, err := k.Seal([]byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return aeadStringPrefix + base64.RawURLEncoding.EncodeToString(envelope), nil
+}
+
+// OpenString reverses SealString. Values without the "enc:" prefix are
+// returned as they are, so config can move to sealed secrets one at a time.
+func (k *AEADKeyring) OpenString(s string) (string, error) {
+	encoded, ok := strings.CutPrefix(s, aeadStringPrefix)
+	if !ok {
+		return s, nil
+	}
+	envelope, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrAEADEnvelope
+	}
+	plaintext, err := k.Open(envelope, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,211 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testAEADKey(t *testing.T) []byte {
+	key := make([]byte, AEADKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAEADSealOpen(t *testing.T) {
+	key := testAEADKey(t)
+	keys := func(kid string) ([]byte, error) {
+		if kid != "k1" {
+			return nil, ErrKeyNotFound
+		}
+		return key, nil
+	}
+	aad := []byte("row 42")
+	for _, alg := range []string{AEADAES256GCM, AEADXChaCha20Poly1305} {
+		envelope, err := AEADSeal(alg, "k1", key, []byte("secret"), aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := AEADOpen(envelope, aad, keys); err != nil || string(got) != "secret" {
+			t.Fatalf("%s: %q %v", alg, got, err)
+		}
+		if _, err := AEADOpen(envelope, []byte("row 43"), keys); !errors.Is(err, ErrAEADOpen) {
+			t.Fatalf("%s: other aad: %v", alg, err)
+		}
+		// every byte is covered, the header included
+		for i := range envelope {
+			tampered := append([]byte{}, envelope...)
+			tampered[i] ^= 1
+			if got, err := AEADOpen(tampered, aad, keys); err == nil || got != nil {
+				t.Fatalf("%s: byte %d flipped and opened", alg, i)
+			}
+		}
+		if _, err := AEADOpen(envelope[:len(envelope)-1], aad, keys); !errors.Is(err, ErrAEADOpen) {
+			t.Fatalf("%s: truncated: %v", alg, err)
+		}
+		if _, err := AEADOpen(envelope[:10], aad, keys); !errors.Is(err, ErrAEADEnvelope) {
+			t.Fatalf("%s: header only: %v", alg, err)
+		}
+	}
+	if _, err := AEADSeal(AEADAES256GCM, "k1", key[:16], nil, nil); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
+
+func TestAEADKeyringRotation(t *testing.T) {
+	ring := NewAEADKeyring("")
+	if err := ring.Add("k1", testAEADKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	old, err := ring.SealString("db-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Add("k2", testAEADKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.SetCurrent("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ring.OpenString(old); err != nil || got != "db-password" {
+		t.Fatalf("old value: %q %v", got, err)
+	}
+	if got, _ := ring.OpenString("plain"); got != "plain" {
+		t.Fatalf("unsealed value %q", got)
+	}
+	envelope, _ := ring.Seal([]byte("x"), nil)
+	if ring.NeedsRotation(envelope) {
+		t.Fatal("current envelope needs rotation")
+	}
+	if err := ring.Remove("k2"); err == nil {
+		t.Fatal("current key removed")
+	}
+	if err := ring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ring.OpenString(old); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("removed key: %v", err)
+	}
+}
+
+// splitAEADStream cuts a sealed stream into its header and chunks.
+func splitAEADStream(t *testing.T, stream []byte, kid string, alg string) ([]byte, [][]byte) {
+	aead, err := newAEAD(alg, make([]byte, AEADKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 3 + len(kid) + aead.NonceSize() - 5
+	header, rest := stream[:n], stream[n:]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		size := aeadChunkSize + aead.Overhead()
+		if size > len(rest) {
+			size = len(rest)
+		}
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	return header, chunks
+}
+
+func TestAEADStream(t *testing.T) {
+	key := testAEADKey(t)
+	keys := func(string) ([]byte, error) { return key, nil }
+	seal := func(alg string, plaintext []byte) []byte {
+		var buf bytes.Buffer
+		w, err := AEADSealWriter(&buf, alg, "k1", key, []byte("backup.tar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// odd sized writes cross chunk boundaries
+		for p := plaintext; len(p) > 0; {
+			n := 1000
+			if n > len(p) {
+				n = len(p)
+			}
+			w.Write(p[:n])
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	open := func(stream []byte) ([]byte, error) {
+		r, err := AEADOpenReader(bytes.NewReader(stream), []byte("backup.tar"), keys)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for _, alg := range []string{AEADAES256GCM, AEADXChaCha20Poly1305} {
+		for _, size := range []int{0, 1, aeadChunkSize - 1, aeadChunkSize, aeadChunkSize + 1, 3 * aeadChunkSize} {
+			plaintext := make([]byte, size)
+			rand.Read(plaintext)
+			got, err := open(seal(alg, plaintext))
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Fatalf("%s %d bytes: %v", alg, size, err)
+			}
+		}
+	}
+}
+
+func TestAEADStreamRejects(t *testing.T) {
+	key := testAEADKey(t)
+	keys := func(string) ([]byte, error) { return key, nil }
+	for _, size := range []int{2*aeadChunkSize + 100, 2 * aeadChunkSize} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		var buf bytes.Buffer
+		w, err := AEADSealWriter(&buf, AEADXChaCha20Poly1305, "k1", key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plaintext)
+		w.Close()
+		stream := buf.Bytes()
+		header, chunks := splitAEADStream(t, stream, "k1", AEADXChaCha20Poly1305)
+		join := func(parts ...[]byte) []byte {
+			return bytes.Join(append([][]byte{header}, parts...), nil)
+		}
+		last := chunks[len(chunks)-1]
+		flipped := append([]byte{}, chunks[1]...)
+		flipped[100] ^= 1
+
+		for _, tc := range []struct {
+			name   string
+			stream []byte
+		}{
+			{"tampered chunk", join(chunks[0], flipped, last)},
+			{"reordered chunks", join(chunks[1], chunks[0], last)},
+			{"duplicated chunk", join(chunks[0], chunks[0], chunks[1], last)},
+			// the chunk before the dropped one was not sealed as the last
+			{"dropped last chunk", join(chunks[:len(chunks)-1]...)},
+			{"truncated last chunk", stream[:len(stream)-1]},
+			{"cut inside a chunk", stream[:len(header)+1000]},
+			{"appended data", append(append([]byte{}, stream...), 0)},
+			{"dropped first chunk", join(chunks[1:]...)},
+		} {
+			r, err := AEADOpenReader(bytes.NewReader(tc.stream), nil, keys)
+			if err != nil {
+				t.Fatalf("%d %s: %v", size, tc.name, err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrAEADOpen) {
+				t.Errorf("%d %s: got %v", size, tc.name, err)
+			}
+		}
+		if r, _ := AEADOpenReader(bytes.NewReader(stream), []byte("other"), keys); r != nil {
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrAEADOpen) {
+				t.Errorf("%d other aad: %v", size, err)
+			}
+		}
+		if _, err := AEADOpenReader(bytes.NewReader(header[:5]), nil, keys); !errors.Is(err, ErrAEADEnvelope) {
+			t.Errorf("%d header cut: %v", size, err)
+		}
+	}
+}
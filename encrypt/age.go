@@ -0,0 +1,134 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Files in the age format, https://age-encryption.org/v1, readable by the
+// age and rage command line tools:
+//
+//	age -d -i key.txt backup.tar.gz.age > backup.tar.gz
+//
+// Recipients are X25519 public keys ("age1...") or a passphrase. age allows
+// a passphrase only as the sole recipient of a file.
+
+// ErrAgeNoRecipient is returned when encrypting to nobody.
+var ErrAgeNoRecipient = errors.New("age: no recipient")
+
+// GenerateAgeIdentity returns a new X25519 secret key
+// ("AGE-SECRET-KEY-1...") and its public key ("age1...").
+func GenerateAgeIdentity() (identity string, recipient string, err error) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", err
+	}
+	return id.String(), id.Recipient().String(), nil
+}
+
+// ParseAgeRecipients parses public keys, one per argument or one per line
+// as in a recipients file, where empty lines and "#" comments are skipped.
+func ParseAgeRecipients(recipients ...string) ([]age.Recipient, error) {
+	return age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+}
+
+// ParseAgeIdentities parses secret keys in the format of age-keygen
+// output, one per line.
+func ParseAgeIdentities(data []byte) ([]age.Identity, error) {
+	return age.ParseIdentities(bytes.NewReader(data))
+}
+
+// LoadAgeIdentityFile is ParseAgeIdentities for a key file on disk.
+func LoadAgeIdentityFile(path string) ([]age.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAgeIdentities(data)
+}
+
+// AgePassphraseRecipient encrypts to a passphrase, the key derived with
+// scrypt at the work factor of the age tool.
+func AgePassphraseRecipient(passphrase string) (age.Recipient, error) {
+	return age.NewScryptRecipient(passphrase)
+}
+
+// AgePassphraseIdentity decrypts files encrypted by AgePassphraseRecipient
+// or "age -p".
+func AgePassphraseIdentity(passphrase string) (age.Identity, error) {
+	return age.NewScryptIdentity(passphrase)
+}
+
+type ageArmorWriter struct {
+	io.WriteCloser
+	armor io.WriteCloser
+}
+
+// Close closes the armor even when the last chunk failed, so its buffer
+// is not left unflushed; the first error is returned.
+func (w *ageArmorWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if aerr := w.armor.Close(); err == nil {
+		err = aerr
+	}
+	return err
+}
+
+// AgeEncryptWriter returns a writer encrypting everything written to it into
+// w, in PEM like ASCII armor when armored is set. Close must be called to
+// write the last chunk, it does not close w.
+func AgeEncryptWriter(w io.Writer, armored bool, recipients ...age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, ErrAgeNoRecipient
+	}
+	if !armored {
+		return age.Encrypt(w, recipients...)
+	}
+	a := armor.NewWriter(w)
+	enc, err := age.Encrypt(a, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return &ageArmorWriter{WriteCloser: enc, armor: a}, nil
+}
+
+// AgeDecryptReader returns a reader of the plaintext of r, armored or not.
+// Each chunk is authenticated as it is read, so errors may also come from
+// Read, and a truncated file fails at its end.
+func AgeDecryptReader(r io.Reader, identities ...age.Identity) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if start, _ := br.Peek(len(armor.Header)); string(start) == armor.Header {
+		return age.Decrypt(armor.NewReader(br), identities...)
+	}
+	return age.Decrypt(br, identities...)
+}
+
+// AgeEncrypt and AgeDecrypt are the in memory forms, for small secrets.
+func AgeEncrypt(plaintext []byte, armored bool, recipients ...age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := AgeEncryptWriter(&buf, armored, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+func AgeDecrypt(ciphertext []byte, identities ...age.Identity) ([]byte, error) {
+	r, err := AgeDecryptReader(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
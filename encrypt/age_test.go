@@ -0,0 +1,122 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestAgeX25519(t *testing.T) {
+	identity1, recipient1, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity2, recipient2, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(identity1, "AGE-SECRET-KEY-1") || !strings.HasPrefix(recipient1, "age1") {
+		t.Fatalf("identity %s recipient %s", identity1, recipient1)
+	}
+	// a recipients file with a comment and an empty line
+	recipients, err := ParseAgeRecipients("# ops\n"+recipient1+"\n", recipient2)
+	if err != nil || len(recipients) != 2 {
+		t.Fatalf("%d recipients: %v", len(recipients), err)
+	}
+	ids1, err := ParseAgeIdentities([]byte("# created: today\n" + identity1 + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids2, _ := ParseAgeIdentities([]byte(identity2))
+
+	plaintext := bytes.Repeat([]byte("metrics "), 10000)
+	for _, armored := range []bool{false, true} {
+		ciphertext, err := AgeEncrypt(plaintext, armored, recipients...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.HasPrefix(ciphertext, []byte(armor.Header)); got != armored {
+			t.Fatalf("armored %v, header %v", armored, got)
+		}
+		for _, ids := range [][]age.Identity{ids1, ids2} {
+			if got, err := AgeDecrypt(ciphertext, ids...); err != nil || !bytes.Equal(got, plaintext) {
+				t.Fatalf("armored %v: %v", armored, err)
+			}
+		}
+		if _, err := AgeDecrypt(ciphertext, mustAgeIdentity(t)); err == nil {
+			t.Fatalf("armored %v: decrypted without a matching key", armored)
+		}
+		if !armored {
+			if _, err := AgeDecrypt(ciphertext[:len(ciphertext)-1], ids1...); err == nil {
+				t.Fatal("truncated file decrypted")
+			}
+		}
+	}
+	if _, err := AgeEncrypt(plaintext, true); !errors.Is(err, ErrAgeNoRecipient) {
+		t.Fatalf("no recipient: %v", err)
+	}
+	if _, err := ParseAgeRecipients("age1notakey"); err == nil {
+		t.Fatal("bad recipient parsed")
+	}
+}
+
+func mustAgeIdentity(t *testing.T) age.Identity {
+	identity, _, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := ParseAgeIdentities([]byte(identity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids[0]
+}
+
+func TestAgePassphrase(t *testing.T) {
+	recipient, err := AgePassphraseRecipient("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the age tool's work factor takes a second, the format is the same
+	recipient.(*age.ScryptRecipient).SetWorkFactor(10)
+	for _, armored := range []bool{false, true} {
+		ciphertext, err := AgeEncrypt([]byte("db-password"), armored, recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		identity, _ := AgePassphraseIdentity("correct horse")
+		if got, err := AgeDecrypt(ciphertext, identity); err != nil || string(got) != "db-password" {
+			t.Fatalf("armored %v: %q %v", armored, got, err)
+		}
+		wrong, _ := AgePassphraseIdentity("correct horse!")
+		if _, err := AgeDecrypt(ciphertext, wrong); err == nil {
+			t.Fatalf("armored %v: wrong passphrase decrypted", armored)
+		}
+	}
+	// a passphrase must be the only recipient
+	_, x25519, _ := GenerateAgeIdentity()
+	recipients, _ := ParseAgeRecipients(x25519)
+	if _, err := AgeEncrypt([]byte("x"), false, append(recipients, recipient)...); err == nil {
+		t.Fatal("passphrase mixed with a key")
+	}
+}
+
+type failingCloser struct{ io.Writer }
+
+func (failingCloser) Close() error { return errors.New("last chunk failed") }
+
+func TestAgeArmorWriterClose(t *testing.T) {
+	var buf bytes.Buffer
+	w := &ageArmorWriter{WriteCloser: failingCloser{io.Discard}, armor: armor.NewWriter(&buf)}
+	if err := w.Close(); err == nil || err.Error() != "last chunk failed" {
+		t.Fatalf("close: %v", err)
+	}
+	if !strings.Contains(buf.String(), armor.Footer) {
+		t.Fatalf("armor not closed: %q", buf.String())
+	}
+}
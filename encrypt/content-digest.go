@@ -0,0 +1,76 @@
+package encrypt
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Content-Digest, https://www.rfc-editor.org/rfc/rfc9530
+
+// ErrContentDigest is returned when a body does not match its Content-Digest.
+var ErrContentDigest = errors.New("content-digest: body does not match")
+
+// Content-Digest algorithms, strongest first.
+const (
+	DigestSha512 = "sha-512"
+	DigestSha256 = "sha-256"
+)
+
+var contentDigestHashes = map[string]crypto.Hash{
+	DigestSha512: crypto.SHA512,
+	DigestSha256: crypto.SHA256,
+}
+
+// ContentDigest hashes body with alg and returns the Content-Digest field value.
+func ContentDigest(alg string, body io.Reader) (string, error) {
+	sum, err := contentDigestSum(alg, body)
+	if err != nil {
+		return "", err
+	}
+	return sfDictionary{{Key: alg, Item: sfItem{Value: sum}}}.serialize()
+}
+
+func contentDigestSum(alg string, body io.Reader) ([]byte, error) {
+	hash, ok := contentDigestHashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("content-digest: unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyContentDigest checks body against the strongest supported digest in
+// the Content-Digest field value.
+func VerifyContentDigest(field string, body io.Reader) error {
+	dict, err := parseSFDictionary(field)
+	if err != nil {
+		return fmt.Errorf("content-digest: %w", err)
+	}
+	for _, alg := range []string{DigestSha512, DigestSha256} {
+		item, ok := dict.get(alg)
+		if !ok {
+			continue
+		}
+		want, ok := item.Value.([]byte)
+		if !ok || len(want) != contentDigestHashes[alg].Size() {
+			return fmt.Errorf("content-digest: malformed %s value", alg)
+		}
+		got, err := contentDigestSum(alg, body)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return ErrContentDigest
+		}
+		return nil
+	}
+	return errors.New("content-digest: no supported algorithm")
+}
@@ -0,0 +1,353 @@
+package encrypt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// HMAC request signing, loosely modelled on AWS SigV4. The client hashes a
+// canonical form of the request and sends
+//
+//	Authorization: HMAC-SHA256 Credential=<key id>, Timestamp=<unix>, Nonce=<nonce>, Signature=<hex>
+//	X-Content-Sha256: <hex sha256 of the body>
+//
+// The canonical request is, one per line: method, host, escaped path,
+// sorted query, timestamp, nonce and the body hash. Signing the host keeps a
+// request from being replayed against another service sharing the seed, so a
+// proxy in front of the verifier must pass the Host header through.
+
+const hmacRequestScheme = "HMAC-SHA256"
+
+var (
+	// ErrRequestSignature is returned for a missing, malformed or wrong request signature.
+	ErrRequestSignature = errors.New("hmac: request signature verification failed")
+	// ErrRequestExpired is returned when the request timestamp is outside the allowed window.
+	ErrRequestExpired = errors.New("hmac: request timestamp outside the allowed window")
+	// ErrRequestReplayed is returned when a nonce is used twice.
+	ErrRequestReplayed = errors.New("hmac: request nonce already used")
+)
+
+// HMACKeyFunc resolves the secret of a key id.
+type HMACKeyFunc func(keyID string) ([]byte, error)
+
+// SeedHMACKeys derives a secret per key id from seed, such as StellarConf.HMACSeed,
+// so clients can be handed their own key without a table on the server.
+func SeedHMACKeys(seed string) HMACKeyFunc {
+	return func(keyID string) ([]byte, error) {
+		if keyID == "" {
+			return nil, fmt.Errorf("%w: empty key id", ErrRequestSignature)
+		}
+		return hmacSum(sha256.New, []byte("request-key:"+keyID), []byte(seed)), nil
+	}
+}
+
+// StaticHMACKeys looks secrets up in a fixed per-client table.
+func StaticHMACKeys(keys map[string]string) HMACKeyFunc {
+	return func(keyID string) ([]byte, error) {
+		secret, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrRequestSignature, keyID)
+		}
+		return []byte(secret), nil
+	}
+}
+
+// canonicalRequest builds the string to sign.
+func canonicalRequest(req *http.Request, timestamp string, nonce string, bodyHash string) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		req.Method,
+		requestHost(req),
+		path,
+		canonicalQuery(req.URL.Query()),
+		timestamp,
+		nonce,
+		bodyHash,
+	}, "\n")
+}
+
+// requestHost is the authority the request is addressed to, req.Host on
+// the server and usually only req.URL.Host on the client.
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if host == "" && req.URL != nil {
+		host = req.URL.Host
+	}
+	return strings.ToLower(host)
+}
+
+// canonicalQuery sorts by key, then value, and escapes both the same way on
+// every side, so "a=1&b=2" and "b=2&a=1" sign the same.
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		sorted := append([]string(nil), values...)
+		sort.Strings(sorted)
+		for _, v := range sorted {
+			pairs = append(pairs, queryEscape(key)+"="+queryEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func queryEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// requestBodyHash returns the hex sha256 of the body, leaving it readable,
+// see requestBody for limit.
+func requestBodyHash(req *http.Request, limit int64) (string, error) {
+	body, err := requestBody(req, limit)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HMACRequestSigner adds the Authorization and X-Content-Sha256 headers.
+type HMACRequestSigner struct {
+	KeyID  string
+	Secret []byte
+	Now    func() time.Time
+}
+
+// SignRequest signs req in place.
+func (s *HMACRequestSigner) SignRequest(req *http.Request) error {
+	bodyHash, err := requestBodyHash(req, 0)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce)
+	sig := hmacSum(sha256.New, []byte(canonicalRequest(req, timestamp, nonceHex, bodyHash)), s.Secret)
+	req.Header.Set("X-Content-Sha256", bodyHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, Timestamp=%s, Nonce=%s, Signature=%s",
+		hmacRequestScheme, s.KeyID, timestamp, nonceHex, hex.EncodeToString(sig)))
+	return nil
+}
+
+// Transport returns a RoundTripper setting Authorization and X-Content-Sha256
+// on a clone of each request, with a fresh nonce and timestamp, before
+// passing it to base, http.DefaultTransport when nil.
+func (s *HMACRequestSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &hmacSignerTransport{signer: s, base: base}
+}
+
+type hmacSignerTransport struct {
+	signer *HMACRequestSigner
+	base   http.RoundTripper
+}
+
+func (t *hmacSignerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := t.signer.SignRequest(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// RestyHook is SignRequest for resty.Client.SetPreRequestHook. resty retries
+// run the hook again, so every attempt gets its own nonce.
+func (s *HMACRequestSigner) RestyHook(_ *resty.Client, req *http.Request) error {
+	return s.SignRequest(req)
+}
+
+// NonceCache remembers nonces for ttl to detect replayed requests.
+type NonceCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// used reports whether nonce was used within ttl, without recording it.
+func (c *NonceCache) used(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.seen[nonce]
+	return ok && !now.After(expires)
+}
+
+// Use records nonce and reports false if it was already used within ttl.
+func (c *NonceCache) Use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > c.ttl/2 {
+		for n, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+	if expires, ok := c.seen[nonce]; ok && !now.After(expires) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
+
+// HMACRequestVerifier checks requests signed by HMACRequestSigner.
+type HMACRequestVerifier struct {
+	Keys HMACKeyFunc
+	// Window is how far the request timestamp may be from now, either way.
+	Window time.Duration
+	// Nonces detects replays, it must remember nonces for at least 2*Window.
+	// Replays are not detected when nil.
+	Nonces *NonceCache
+	// MaxBodyBytes bounds the body buffered to check its hash, 10 MiB by
+	// default, negative for no limit.
+	MaxBodyBytes int64
+	Now          func() time.Time
+}
+
+// NewHMACRequestVerifier returns a verifier with a 5 minute window and its own nonce cache.
+func NewHMACRequestVerifier(keys HMACKeyFunc) *HMACRequestVerifier {
+	window := 5 * time.Minute
+	return &HMACRequestVerifier{
+		Keys:   keys,
+		Window: window,
+		Nonces: NewNonceCache(2 * window),
+	}
+}
+
+// parseHMACAuthorization reads the Authorization header set by HMACRequestSigner.
+func parseHMACAuthorization(header string) (map[string]string, error) {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || scheme != hmacRequestScheme {
+		return nil, fmt.Errorf("%w: missing %s authorization", ErrRequestSignature, hmacRequestScheme)
+	}
+	out := make(map[string]string, 4)
+	for _, p := range strings.Split(params, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed authorization", ErrRequestSignature)
+		}
+		out[k] = v
+	}
+	for _, k := range []string{"Credential", "Timestamp", "Nonce", "Signature"} {
+		if out[k] == "" {
+			return nil, fmt.Errorf("%w: missing %s", ErrRequestSignature, k)
+		}
+	}
+	return out, nil
+}
+
+// VerifyRequest checks the signature of req and returns the key id that signed it.
+// The timestamp, nonce and key id are checked before the body is read.
+func (v *HMACRequestVerifier) VerifyRequest(req *http.Request) (string, error) {
+	auth, err := parseHMACAuthorization(req.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	ts, err := strconv.ParseInt(auth["Timestamp"], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed timestamp", ErrRequestSignature)
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > v.Window || d < -v.Window {
+		return "", ErrRequestExpired
+	}
+	nonce := auth["Credential"] + ":" + auth["Nonce"]
+	if v.Nonces != nil && v.Nonces.used(nonce, now) {
+		return "", ErrRequestReplayed
+	}
+	sig, err := hex.DecodeString(auth["Signature"])
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed signature", ErrRequestSignature)
+	}
+	secret, err := v.Keys(auth["Credential"])
+	if err != nil {
+		return "", err
+	}
+	// the body hash is recomputed, X-Content-Sha256 is only a hint for proxies
+	limit := v.MaxBodyBytes
+	if limit == 0 {
+		limit = defaultMaxBodyBytes
+	}
+	bodyHash, err := requestBodyHash(req, limit)
+	if err != nil {
+		return "", err
+	}
+	want := hmacSum(sha256.New, []byte(canonicalRequest(req, auth["Timestamp"], auth["Nonce"], bodyHash)), secret)
+	if !hmac.Equal(sig, want) {
+		return "", ErrRequestSignature
+	}
+	// only a valid signature may burn a nonce
+	if v.Nonces != nil && !v.Nonces.Use(nonce, now) {
+		return "", ErrRequestReplayed
+	}
+	return auth["Credential"], nil
+}
+
+type hmacRequestContextKey struct{}
+
+// HMACRequestKeyFromContext returns the key id verified by HMACRequestVerifier.Middleware.
+func HMACRequestKeyFromContext(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(hmacRequestContextKey{}).(string)
+	return keyID, ok
+}
+
+// Middleware refuses stale, replayed or badly signed requests with 401 and
+// bodies over MaxBodyBytes with 413. next gets the key id of the caller
+// through HMACRequestKeyFromContext.
+func (v *HMACRequestVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID, err := v.VerifyRequest(r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hmacRequestContextKey{}, keyID)))
+	})
+}
@@ -0,0 +1,120 @@
+package encrypt
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// untouchedBody fails the test when the verifier reads it.
+type untouchedBody struct{ t *testing.T }
+
+func (b untouchedBody) Read([]byte) (int, error) {
+	b.t.Error("body read before the signature was checked")
+	return 0, io.EOF
+}
+
+func (untouchedBody) Close() error { return nil }
+
+func TestHMACRequestVerify(t *testing.T) {
+	keys := SeedHMACKeys("seed")
+	secret, _ := keys("client-1")
+	now := time.Unix(1700000000, 0)
+	signer := &HMACRequestSigner{KeyID: "client-1", Secret: secret, Now: func() time.Time { return now }}
+	verifier := NewHMACRequestVerifier(keys)
+	verifier.Now = func() time.Time { return now }
+
+	signed := func(body string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "https://api.internal/v1/jobs?b=2&a=1", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.SignRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(req.Method, req.URL.String(), strings.NewReader(body))
+		r.Header = req.Header.Clone()
+		return r
+	}
+
+	req := signed(`{"job":1}`)
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(strings.NewReader(`{"job":1}`))
+	if keyID, err := verifier.VerifyRequest(req); err != nil || keyID != "client-1" {
+		t.Fatalf("verify: %q %v", keyID, err)
+	}
+	if rest, _ := io.ReadAll(req.Body); string(rest) != `{"job":1}` {
+		t.Fatalf("body not readable after verification: %q", rest)
+	}
+
+	tampered := signed(`{"job":1}`)
+	tampered.Body = io.NopCloser(strings.NewReader(`{"job":2}`))
+	if _, err := verifier.VerifyRequest(tampered); !errors.Is(err, ErrRequestSignature) {
+		t.Fatalf("tampered body: %v", err)
+	}
+
+	// stale, replayed and unknown key requests are refused without reading the body
+	replay.Body = untouchedBody{t}
+	if _, err := verifier.VerifyRequest(replay); !errors.Is(err, ErrRequestReplayed) {
+		t.Fatalf("replay: %v", err)
+	}
+	stale := signed("x")
+	stale.Body = untouchedBody{t}
+	verifier.Now = func() time.Time { return now.Add(10 * time.Minute) }
+	if _, err := verifier.VerifyRequest(stale); !errors.Is(err, ErrRequestExpired) {
+		t.Fatalf("stale: %v", err)
+	}
+	verifier.Now = func() time.Time { return now }
+	unknown := signed("x")
+	unknown.Body = untouchedBody{t}
+	unknown.Header.Set("Authorization", strings.Replace(unknown.Header.Get("Authorization"), "client-1", "", 1))
+	if _, err := verifier.VerifyRequest(unknown); !errors.Is(err, ErrRequestSignature) {
+		t.Fatalf("no key id: %v", err)
+	}
+
+	verifier.MaxBodyBytes = 8
+	var tooLarge *http.MaxBytesError
+	if _, err := verifier.VerifyRequest(signed(strings.Repeat("x", 64))); !errors.As(err, &tooLarge) {
+		t.Fatalf("body over the limit: %v", err)
+	}
+}
+
+func TestHMACRequestMiddleware(t *testing.T) {
+	keys := StaticHMACKeys(map[string]string{"c1": strings.Repeat("k", 32)})
+	verifier := NewHMACRequestVerifier(keys)
+	verifier.MaxBodyBytes = 1024
+	srv := httptest.NewServer(verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID, _ := HMACRequestKeyFromContext(r.Context())
+		io.WriteString(w, keyID)
+	})))
+	defer srv.Close()
+	secret, _ := keys("c1")
+	client := &http.Client{Transport: (&HMACRequestSigner{KeyID: "c1", Secret: secret}).Transport(nil)}
+
+	for _, tc := range []struct {
+		client *http.Client
+		body   string
+		status int
+	}{
+		{client, "hello", http.StatusOK},
+		{client, strings.Repeat("x", 4096), http.StatusRequestEntityTooLarge},
+		{http.DefaultClient, "hello", http.StatusUnauthorized},
+	} {
+		resp, err := tc.client.Post(srv.URL+"/v1/jobs", "text/plain", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%d byte body: %s %s", len(tc.body), resp.Status, got)
+		}
+		if tc.status == http.StatusOK && string(got) != "c1" {
+			t.Fatalf("key id %q", got)
+		}
+	}
+}
@@ -1,13 +1,137 @@
 package encrypt
 
 import (
+	"bytes"
 	"crypto/hmac"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrHmacMismatch is returned by HmacVerify when the signature does not match.
+var ErrHmacMismatch = errors.New("hmac: signature mismatch")
+
+// HMAC hash algorithms. SHA-1 is only here for partners that still require it.
+const (
+	HmacSHA1     = "sha1"
+	HmacSHA256   = "sha256"
+	HmacSHA384   = "sha384"
+	HmacSHA512   = "sha512"
+	HmacSHA3_256 = "sha3-256"
+	HmacSHA3_384 = "sha3-384"
+	HmacSHA3_512 = "sha3-512"
 )
 
+// Output encodings for HmacSign and HmacVerify.
+const (
+	EncodingHex       = "hex"
+	EncodingBase64    = "base64"
+	EncodingBase64URL = "base64url"
+)
+
+var hmacHashes = map[string]func() hash.Hash{
+	HmacSHA1:     sha1.New,
+	HmacSHA256:   sha256.New,
+	HmacSHA384:   sha512.New384,
+	HmacSHA512:   sha512.New,
+	HmacSHA3_256: sha3.New256,
+	HmacSHA3_384: sha3.New384,
+	HmacSHA3_512: sha3.New512,
+}
+
+// HmacSha256 returns the hex HMAC-SHA256 of data,
+// see HmacSign for other algorithms and encodings.
 func HmacSha256(data string, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(data))
-	return hex.EncodeToString(h.Sum(nil))
-}
\ No newline at end of file
+	return hex.EncodeToString(hmacSum(sha256.New, []byte(data), []byte(secret)))
+}
+
+func hmacSum(h func() hash.Hash, data []byte, secret []byte) []byte {
+	mac := hmac.New(h, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// HmacNew returns a running HMAC, to be fed as an io.Writer alongside other work.
+func HmacNew(alg string, secret []byte) (hash.Hash, error) {
+	h, ok := hmacHashes[alg]
+	if !ok {
+		return nil, fmt.Errorf("hmac: unsupported algorithm %q", alg)
+	}
+	return hmac.New(h, secret), nil
+}
+
+// HmacSum streams data through the HMAC, so large files are never held in memory.
+func HmacSum(alg string, secret []byte, data io.Reader) ([]byte, error) {
+	mac, err := HmacNew(alg, secret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(mac, data); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// HmacSign returns the HMAC of data in the given encoding.
+func HmacSign(alg string, secret []byte, data io.Reader, encoding string) (string, error) {
+	sum, err := HmacSum(alg, secret, data)
+	if err != nil {
+		return "", err
+	}
+	return hmacEncode(sum, encoding)
+}
+
+// HmacVerify checks sig, in the given encoding, against the HMAC of data in constant time.
+func HmacVerify(alg string, secret []byte, data io.Reader, sig string, encoding string) error {
+	want, err := hmacDecode(sig, encoding)
+	if err != nil {
+		return ErrHmacMismatch
+	}
+	sum, err := HmacSum(alg, secret, data)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sum, want) {
+		return ErrHmacMismatch
+	}
+	return nil
+}
+
+// HmacVerifyBytes is HmacVerify for data already in memory.
+func HmacVerifyBytes(alg string, secret []byte, data []byte, sig string, encoding string) error {
+	return HmacVerify(alg, secret, bytes.NewReader(data), sig, encoding)
+}
+
+func hmacEncode(sum []byte, encoding string) (string, error) {
+	switch encoding {
+	case EncodingHex:
+		return hex.EncodeToString(sum), nil
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(sum), nil
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("hmac: unsupported encoding %q", encoding)
+}
+
+// hmacDecode accepts base64 with or without padding.
+func hmacDecode(sig string, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingHex:
+		return hex.DecodeString(sig)
+	case EncodingBase64:
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(sig, "="))
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(sig, "="))
+	}
+	return nil, fmt.Errorf("hmac: unsupported encoding %q", encoding)
+}
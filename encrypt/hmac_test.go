@@ -0,0 +1,95 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// https://tools.ietf.org/html/rfc4231#section-4 and, for SHA-1,
+// https://tools.ietf.org/html/rfc2202#section-3
+func TestHmacRFC4231(t *testing.T) {
+	key1 := bytes.Repeat([]byte{0x0b}, 20)
+	key6 := bytes.Repeat([]byte{0xaa}, 131)
+	data6 := "Test Using Larger Than Block-Size Key - Hash Key First"
+	for _, tc := range []struct {
+		name, alg string
+		key       []byte
+		data      string
+		want      string
+	}{
+		{"1", HmacSHA1, key1, "Hi There", "b617318655057264e28bc0b6fb378c8ef146be00"},
+		{"1", HmacSHA256, key1, "Hi There", "b0344c61d8db38535ca8afceaf0bf12b881dc200c9833da726e9376c2e32cff7"},
+		{"1", HmacSHA384, key1, "Hi There", "afd03944d84895626b0825f4ab46907f15f9dadbe4101ec682aa034c7cebc59cfaea9ea9076ede7f4af152e8b2fa9cb6"},
+		{"1", HmacSHA512, key1, "Hi There", "87aa7cdea5ef619d4ff0b4241a1d6cb02379f4e2ce4ec2787ad0b30545e17cdedaa833b7d6b8a702038b274eaea3f4e4be9d914eeb61f1702e696c203a126854"},
+		{"2", HmacSHA1, []byte("Jefe"), "what do ya want for nothing?", "effcdf6ae5eb2fa2d27416d5f184df9c259a7c79"},
+		{"2", HmacSHA256, []byte("Jefe"), "what do ya want for nothing?", "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"2", HmacSHA384, []byte("Jefe"), "what do ya want for nothing?", "af45d2e376484031617f78d2b58a6b1b9c7ef464f5a01b47e42ec3736322445e8e2240ca5e69e2c78b3239ecfab21649"},
+		{"2", HmacSHA512, []byte("Jefe"), "what do ya want for nothing?", "164b7a7bfcf819e2e395fbe73b56e0a387bd64222e831fd610270cd7ea2505549758bf75c05a994a6d034f65f8f0e6fdcaeab1a34d4a6b4b636e070a38bce737"},
+		{"6", HmacSHA256, key6, data6, "60e431591ee0b67f0d8a26aacbf5b77f8e0bc6213728c5140546040f0ee37f54"},
+		{"6", HmacSHA512, key6, data6, "80b24263c7c1a3ebb71493c1dd7be8b49b46d1f41b4aeec1121b013783f8f3526b56d037e05f2598bd0fd2215d6a1e5295e64f73f63f0aec8b915a985d786598"},
+	} {
+		got, err := HmacSign(tc.alg, tc.key, strings.NewReader(tc.data), EncodingHex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("case %s %s: %s, want %s", tc.name, tc.alg, got, tc.want)
+		}
+		if err := HmacVerify(tc.alg, tc.key, strings.NewReader(tc.data), tc.want, EncodingHex); err != nil {
+			t.Errorf("case %s %s: %v", tc.name, tc.alg, err)
+		}
+	}
+	if got := HmacSha256("what do ya want for nothing?", "Jefe"); got != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("HmacSha256: %s", got)
+	}
+}
+
+func TestHmacEncodings(t *testing.T) {
+	secret := []byte("Jefe")
+	data := "what do ya want for nothing?"
+	sum, _ := hex.DecodeString("5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843")
+	for _, tc := range []struct {
+		encoding string
+		sigs     []string
+	}{
+		{EncodingHex, []string{hex.EncodeToString(sum)}},
+		// padding is optional on input
+		{EncodingBase64, []string{"W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM=", "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM"}},
+		{EncodingBase64URL, []string{"W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM"}},
+	} {
+		got, err := HmacSign(HmacSHA256, secret, strings.NewReader(data), tc.encoding)
+		if err != nil || got != tc.sigs[0] {
+			t.Fatalf("%s: %s %v", tc.encoding, got, err)
+		}
+		for _, sig := range tc.sigs {
+			if err := HmacVerify(HmacSHA256, secret, strings.NewReader(data), sig, tc.encoding); err != nil {
+				t.Fatalf("%s %s: %v", tc.encoding, sig, err)
+			}
+		}
+	}
+
+	sig := hex.EncodeToString(sum)
+	for _, tc := range []struct {
+		name string
+		data string
+		sig  string
+	}{
+		{"tampered data", data + ".", sig},
+		{"tampered signature", data, sig[:len(sig)-1] + "0"},
+		{"truncated signature", data, sig[:32]},
+		{"malformed signature", data, "zz"},
+	} {
+		if err := HmacVerifyBytes(HmacSHA256, secret, []byte(tc.data), tc.sig, EncodingHex); !errors.Is(err, ErrHmacMismatch) {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+	if _, err := HmacSign("md5", secret, strings.NewReader(data), EncodingHex); err == nil {
+		t.Error("md5 accepted")
+	}
+	if _, err := HmacSign(HmacSHA256, secret, strings.NewReader(data), "base32"); err == nil {
+		t.Error("base32 accepted")
+	}
+}
@@ -0,0 +1,652 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// HTTP Message Signatures, https://www.rfc-editor.org/rfc/rfc9421
+// Only requests are signed here, response signatures are not supported.
+
+var (
+	// ErrHTTPSignatureMissing is returned when a request has no signature to verify.
+	ErrHTTPSignatureMissing = errors.New("httpsig: request is not signed")
+	// ErrHTTPSignature is returned when a signature is malformed, stale or does not verify.
+	ErrHTTPSignature = errors.New("httpsig: signature verification failed")
+)
+
+// HTTP signature algorithms, https://www.rfc-editor.org/rfc/rfc9421#section-3.3
+const (
+	HTTPSigRsaPssSha512    = "rsa-pss-sha512"
+	HTTPSigRsaV15Sha256    = "rsa-v1_5-sha256"
+	HTTPSigHmacSha256      = "hmac-sha256"
+	HTTPSigEcdsaP256Sha256 = "ecdsa-p256-sha256"
+	HTTPSigEcdsaP384Sha384 = "ecdsa-p384-sha384"
+	HTTPSigEd25519         = "ed25519"
+)
+
+// httpSigJwsAlgs maps each algorithm to the JWS one producing the same signature bytes.
+var httpSigJwsAlgs = map[string]string{
+	HTTPSigRsaPssSha512:    AlgPS512,
+	HTTPSigRsaV15Sha256:    AlgRS256,
+	HTTPSigHmacSha256:      AlgHS256,
+	HTTPSigEcdsaP256Sha256: AlgES256,
+	HTTPSigEcdsaP384Sha384: AlgES384,
+	HTTPSigEd25519:         AlgEdDSA,
+}
+
+// DefaultHTTPSignatureComponents are covered when HTTPSigner.Components is empty.
+var DefaultHTTPSignatureComponents = []string{"@method", "@authority", "@path", "@query"}
+
+// httpSigClockSkew is how far in the future "created" may be.
+const httpSigClockSkew = time.Minute
+
+// httpSigDefaultAlgorithm picks the algorithm for pub, RSA keys default to PSS.
+func httpSigDefaultAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case []byte:
+		return HTTPSigHmacSha256, nil
+	case *rsa.PublicKey:
+		return HTTPSigRsaPssSha512, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().Name {
+		case "P-256":
+			return HTTPSigEcdsaP256Sha256, nil
+		case "P-384":
+			return HTTPSigEcdsaP384Sha384, nil
+		}
+	case ed25519.PublicKey:
+		return HTTPSigEd25519, nil
+	}
+	return "", ErrUnsupportedKey
+}
+
+// HTTPSigner adds Signature-Input and Signature headers to outgoing requests.
+type HTTPSigner struct {
+	KeyID string
+	// Key is the []byte secret for hmac-sha256 or a crypto.Signer.
+	Key interface{}
+	// Algorithm defaults from the key, rsa-pss-sha512 for RSA keys.
+	Algorithm string
+	// Label names the signature in the headers, "sig1" by default.
+	Label string
+	// Components are the covered component identifiers, such as "@method",
+	// "content-type" or `@query-param;name="id"`.
+	Components []string
+	// ContentDigest, when set to DigestSha256 or DigestSha512, adds a
+	// Content-Digest header to requests with a body and covers it.
+	ContentDigest string
+	// Expires adds an "expires" parameter this long after signing.
+	Expires time.Duration
+	// Nonce adds a random "nonce" parameter.
+	Nonce bool
+	Tag   string
+	Now   func() time.Time
+}
+
+// SignRequest signs req in place. Signing the body digest reads the body
+// through req.GetBody when set, otherwise it is buffered and replaced.
+func (s *HTTPSigner) SignRequest(req *http.Request) error {
+	alg := s.Algorithm
+	if alg == "" {
+		pub, err := jwsVerificationKey(s.Key)
+		if err != nil {
+			return err
+		}
+		if alg, err = httpSigDefaultAlgorithm(pub); err != nil {
+			return err
+		}
+	}
+	jwsAlg, ok := httpSigJwsAlgs[alg]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+	signer, secret, err := jwsSigningKey(s.Key, jwsAlg)
+	if err != nil {
+		return err
+	}
+
+	names := s.Components
+	if len(names) == 0 {
+		names = DefaultHTTPSignatureComponents
+	}
+	components := make([]sfItem, 0, len(names)+1)
+	digestCovered := false
+	for _, name := range names {
+		c, err := parseHTTPSigComponent(name)
+		if err != nil {
+			return err
+		}
+		digestCovered = digestCovered || c.Value == "content-digest"
+		components = append(components, c)
+	}
+	if s.ContentDigest != "" && req.Body != nil && req.Body != http.NoBody {
+		body, err := requestBody(req, 0)
+		if err != nil {
+			return err
+		}
+		digest, err := ContentDigest(s.ContentDigest, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Digest", digest)
+		if !digestCovered {
+			components = append(components, sfItem{Value: "content-digest"})
+		}
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	params := []sfParam{{Key: "created", Value: now.Unix()}}
+	if s.Expires > 0 {
+		params = append(params, sfParam{Key: "expires", Value: now.Add(s.Expires).Unix()})
+	}
+	if s.KeyID != "" {
+		params = append(params, sfParam{Key: "keyid", Value: s.KeyID})
+	}
+	params = append(params, sfParam{Key: "alg", Value: alg})
+	if s.Nonce {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		params = append(params, sfParam{Key: "nonce", Value: base64.RawURLEncoding.EncodeToString(nonce)})
+	}
+	if s.Tag != "" {
+		params = append(params, sfParam{Key: "tag", Value: s.Tag})
+	}
+	input := sfItem{Value: components, Params: params}
+
+	base, err := httpSignatureBase(req, input)
+	if err != nil {
+		return err
+	}
+	d, err := newJwsDigest(jwsAlg, secret)
+	if err != nil {
+		return err
+	}
+	d.Write(base)
+	sig, err := d.sign(signer)
+	if err != nil {
+		return err
+	}
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	if err := setSFDictionaryMember(req.Header, "Signature-Input", label, input); err != nil {
+		return err
+	}
+	return setSFDictionaryMember(req.Header, "Signature", label, sfItem{Value: sig})
+}
+
+// Transport returns a RoundTripper adding Signature-Input and Signature, and
+// Content-Digest when configured, to a clone of each request before handing
+// it to base, http.DefaultTransport when nil.
+func (s *HTTPSigner) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &httpSignerTransport{signer: s, base: base}
+}
+
+type httpSignerTransport struct {
+	signer *HTTPSigner
+	base   http.RoundTripper
+}
+
+func (t *httpSignerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	if err := t.signer.SignRequest(req); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// RestyHook is SignRequest as a resty.Client.SetPreRequestHook callback, it
+// runs after resty has set the final headers so they can be covered.
+func (s *HTTPSigner) RestyHook(_ *resty.Client, req *http.Request) error {
+	return s.SignRequest(req)
+}
+
+// setSFDictionaryMember sets key in the dictionary field, keeping other members
+// such as signatures added by someone else.
+func setSFDictionaryMember(h http.Header, field string, key string, item sfItem) error {
+	dict, err := parseSFDictionary(strings.Join(h.Values(field), ","))
+	if err != nil {
+		dict = nil
+	}
+	value, err := dict.set(key, item).serialize()
+	if err != nil {
+		return err
+	}
+	h.Set(field, value)
+	return nil
+}
+
+// defaultMaxBodyBytes bounds the request bodies verifiers buffer.
+const defaultMaxBodyBytes = 10 << 20
+
+// requestBody returns a fresh reader over the body of req and leaves req.Body
+// readable for whoever comes next. A body that has to be buffered and is
+// larger than limit fails with *http.MaxBytesError, limit <= 0 means no limit.
+func requestBody(req *http.Request, limit int64) (io.ReadCloser, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return http.NoBody, nil
+	}
+	if req.GetBody != nil {
+		return req.GetBody()
+	}
+	r := req.Body
+	if limit > 0 {
+		r = http.MaxBytesReader(nil, r, limit)
+	}
+	b, err := io.ReadAll(r)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req.GetBody()
+}
+
+// parseHTTPSigComponent parses a component identifier such as `@query-param;name="id"`.
+func parseHTTPSigComponent(name string) (sfItem, error) {
+	id, params, _ := strings.Cut(name, ";")
+	if params != "" {
+		params = ";" + params
+	}
+	c, err := parseSFItem(`"` + id + `"` + params)
+	if err != nil {
+		return sfItem{}, fmt.Errorf("httpsig: invalid component %q", name)
+	}
+	return c, nil
+}
+
+// httpSigComponentName formats c the way HTTPSigner.Components takes it.
+func httpSigComponentName(c sfItem) string {
+	name, _ := c.Value.(string)
+	s, _ := sfItem{Value: sfToken(""), Params: c.Params}.serialize()
+	return name + s
+}
+
+// httpSignatureBase builds the signature base for the covered components and
+// parameters in input, https://www.rfc-editor.org/rfc/rfc9421#section-2.5
+func httpSignatureBase(req *http.Request, input sfItem) ([]byte, error) {
+	components, ok := input.Value.([]sfItem)
+	if !ok {
+		return nil, fmt.Errorf("%w: signature input is not an inner list", ErrHTTPSignature)
+	}
+	var b bytes.Buffer
+	seen := make(map[string]bool, len(components))
+	for _, c := range components {
+		id, err := c.serialize()
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("httpsig: component %s covered twice", id)
+		}
+		seen[id] = true
+		value, err := httpSigComponentValue(req, c)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(id)
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	params, err := input.serialize()
+	if err != nil {
+		return nil, err
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(params)
+	return b.Bytes(), nil
+}
+
+// httpSigComponentValue returns the value of a derived component or header field.
+// https://www.rfc-editor.org/rfc/rfc9421#section-2.1
+func httpSigComponentValue(req *http.Request, c sfItem) (string, error) {
+	name, ok := c.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: component identifier is not a string", ErrHTTPSignature)
+	}
+	for _, p := range c.Params {
+		if name != "@query-param" || p.Key != "name" {
+			return "", fmt.Errorf("httpsig: unsupported parameter %q on %q", p.Key, name)
+		}
+	}
+	switch name {
+	case "@method":
+		return req.Method, nil
+	case "@target-uri":
+		return httpSigScheme(req) + "://" + httpSigAuthority(req) + req.URL.RequestURI(), nil
+	case "@authority":
+		return httpSigAuthority(req), nil
+	case "@scheme":
+		return httpSigScheme(req), nil
+	case "@request-target":
+		return req.URL.RequestURI(), nil
+	case "@path":
+		path := req.URL.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return path, nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	case "@query-param":
+		param, _ := c.param("name")
+		key, ok := param.(string)
+		if !ok {
+			return "", errors.New(`httpsig: @query-param needs a "name" parameter`)
+		}
+		values := req.URL.Query()[key]
+		if len(values) != 1 {
+			return "", fmt.Errorf("httpsig: query parameter %q must occur exactly once", key)
+		}
+		// https://www.rfc-editor.org/rfc/rfc9421#section-2.2.8
+		return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("httpsig: unsupported derived component %q", name)
+	}
+	if name != strings.ToLower(name) {
+		return "", fmt.Errorf("httpsig: header component %q must be lowercase", name)
+	}
+	values := req.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("httpsig: covered header %q is missing", name)
+	}
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return strings.Join(values, ", "), nil
+}
+
+func httpSigScheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return strings.ToLower(req.URL.Scheme)
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// httpSigAuthority is the lowercased host, without the scheme's default port.
+func httpSigAuthority(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host = strings.ToLower(host)
+	switch scheme := httpSigScheme(req); {
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = strings.TrimSuffix(host, ":443")
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = strings.TrimSuffix(host, ":80")
+	}
+	return host
+}
+
+// HTTPSignatureKey is a verification key and the algorithm it is bound to.
+type HTTPSignatureKey struct {
+	// Key is the []byte secret for hmac-sha256, a public key or a JWK.
+	Key interface{}
+	// Algorithm the key must be used with. When empty the "alg" parameter
+	// is trusted, falling back to the default for the key type.
+	Algorithm string
+}
+
+// HTTPSignatureResult describes a verified signature.
+type HTTPSignatureResult struct {
+	Label      string
+	KeyID      string
+	Algorithm  string
+	Nonce      string
+	Tag        string
+	Created    time.Time
+	Expires    time.Time
+	Components []string
+}
+
+// HTTPVerifier checks Signature-Input and Signature headers of incoming requests.
+type HTTPVerifier struct {
+	// Keys resolves the "keyid" parameter.
+	Keys func(keyID string) (HTTPSignatureKey, error)
+	// Label restricts verification to one signature, by default any may verify.
+	Label string
+	// Required components must be covered, "@method", "@authority" and
+	// "@path" by default.
+	Required []string
+	// RequireContentDigest makes "content-digest" mandatory for requests with a body.
+	RequireContentDigest bool
+	// MaxAge rejects signatures created longer ago, 5 minutes by default,
+	// negative to only check "expires".
+	MaxAge time.Duration
+	// MaxBodyBytes bounds the body buffered to check a covered Content-Digest,
+	// 10 MiB by default, negative for no limit.
+	MaxBodyBytes int64
+	Now          func() time.Time
+}
+
+type httpSignatureContextKey struct{}
+
+// HTTPSignatureFromContext returns the signature verified by HTTPVerifier.Middleware.
+func HTTPSignatureFromContext(ctx context.Context) (*HTTPSignatureResult, bool) {
+	res, ok := ctx.Value(httpSignatureContextKey{}).(*HTTPSignatureResult)
+	return res, ok
+}
+
+// Middleware rejects requests without a signature that verifies, 401, or with
+// a body over MaxBodyBytes, 413, and hands the HTTPSignatureResult to next
+// through HTTPSignatureFromContext.
+func (v *HTTPVerifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := v.VerifyRequest(r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), httpSignatureContextKey{}, res)))
+	})
+}
+
+// VerifyRequest returns the first signature of req that verifies.
+// A covered Content-Digest is checked against the body, which stays readable
+// and is only read once a signature verified.
+func (v *HTTPVerifier) VerifyRequest(req *http.Request) (*HTTPSignatureResult, error) {
+	if v.Keys == nil {
+		return nil, errors.New("httpsig: no key lookup configured")
+	}
+	rawInput, rawSig := req.Header.Values("Signature-Input"), req.Header.Values("Signature")
+	if len(rawInput) == 0 || len(rawSig) == 0 {
+		return nil, ErrHTTPSignatureMissing
+	}
+	inputs, err := parseSFDictionary(strings.Join(rawInput, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Signature-Input: %s", ErrHTTPSignature, err)
+	}
+	sigs, err := parseSFDictionary(strings.Join(rawSig, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Signature: %s", ErrHTTPSignature, err)
+	}
+	lastErr := ErrHTTPSignatureMissing
+	for _, m := range inputs {
+		if v.Label != "" && m.Key != v.Label {
+			continue
+		}
+		sig, ok := sigs.get(m.Key)
+		if !ok {
+			lastErr = fmt.Errorf("%w: no signature for label %q", ErrHTTPSignature, m.Key)
+			continue
+		}
+		res, err := v.verify(req, m.Key, m.Item, sig)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (v *HTTPVerifier) verify(req *http.Request, label string, input sfItem, sigItem sfItem) (*HTTPSignatureResult, error) {
+	components, ok := input.Value.([]sfItem)
+	sig, ok2 := sigItem.Value.([]byte)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("%w: malformed signature %q", ErrHTTPSignature, label)
+	}
+	res := &HTTPSignatureResult{Label: label}
+	for _, p := range input.Params {
+		var ok bool
+		switch p.Key {
+		case "created", "expires":
+			var n int64
+			if n, ok = p.Value.(int64); ok && p.Key == "created" {
+				res.Created = time.Unix(n, 0)
+			} else if ok {
+				res.Expires = time.Unix(n, 0)
+			}
+		case "keyid":
+			res.KeyID, ok = p.Value.(string)
+		case "alg":
+			res.Algorithm, ok = p.Value.(string)
+		case "nonce":
+			res.Nonce, ok = p.Value.(string)
+		case "tag":
+			res.Tag, ok = p.Value.(string)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed %q parameter", ErrHTTPSignature, p.Key)
+		}
+	}
+	covered := make(map[string]bool, len(components))
+	for _, c := range components {
+		name := httpSigComponentName(c)
+		res.Components = append(res.Components, name)
+		covered[name] = true
+	}
+	required := v.Required
+	if required == nil {
+		required = []string{"@method", "@authority", "@path"}
+	}
+	if v.RequireContentDigest && req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+		required = append(required[:len(required):len(required)], "content-digest")
+	}
+	for _, name := range required {
+		if !covered[name] {
+			return nil, fmt.Errorf("%w: %q is not covered", ErrHTTPSignature, name)
+		}
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = 5 * time.Minute
+	}
+	switch {
+	case res.Created.IsZero():
+		return nil, fmt.Errorf("%w: missing \"created\"", ErrHTTPSignature)
+	case res.Created.After(now.Add(httpSigClockSkew)):
+		return nil, fmt.Errorf("%w: created in the future", ErrHTTPSignature)
+	case maxAge > 0 && now.Sub(res.Created) > maxAge:
+		return nil, fmt.Errorf("%w: signature is too old", ErrHTTPSignature)
+	case !res.Expires.IsZero() && now.After(res.Expires):
+		return nil, fmt.Errorf("%w: signature expired", ErrHTTPSignature)
+	}
+
+	if res.KeyID == "" {
+		return nil, fmt.Errorf("%w: missing \"keyid\"", ErrHTTPSignature)
+	}
+	key, err := v.Keys(res.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := jwsVerificationKey(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	alg := key.Algorithm
+	switch {
+	case alg != "" && res.Algorithm != "" && alg != res.Algorithm:
+		return nil, fmt.Errorf("%w: key %q is bound to %s", ErrHTTPSignature, res.KeyID, alg)
+	case alg == "":
+		alg = res.Algorithm
+	}
+	if alg == "" {
+		if alg, err = httpSigDefaultAlgorithm(pub); err != nil {
+			return nil, err
+		}
+	}
+	res.Algorithm = alg
+	jwsAlg, ok := httpSigJwsAlgs[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	base, err := httpSignatureBase(req, input)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newJwsVerifyDigest(pub, jwsAlg)
+	if err != nil {
+		return nil, err
+	}
+	d.Write(base)
+	if err := d.verify(pub, sig); err != nil {
+		return nil, fmt.Errorf("%w: label %q", ErrHTTPSignature, label)
+	}
+	if covered["content-digest"] {
+		limit := v.MaxBodyBytes
+		if limit == 0 {
+			limit = defaultMaxBodyBytes
+		}
+		body, err := requestBody(req, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if err := VerifyContentDigest(strings.Join(req.Header.Values("Content-Digest"), ","), body); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSignatureMiddleware(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &HTTPVerifier{
+		Keys: func(keyID string) (HTTPSignatureKey, error) {
+			if keyID != "k1" {
+				return HTTPSignatureKey{}, errors.New("unknown key")
+			}
+			return HTTPSignatureKey{Key: &key.PublicKey, Algorithm: HTTPSigEcdsaP256Sha256}, nil
+		},
+		RequireContentDigest: true,
+		MaxBodyBytes:         1024,
+	}
+	srv := httptest.NewServer(verifier.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := HTTPSignatureFromContext(r.Context())
+		body, _ := io.ReadAll(r.Body)
+		if !ok || res.KeyID != "k1" {
+			t.Errorf("result %+v", res)
+		}
+		w.Write(body)
+	})))
+	defer srv.Close()
+
+	signer := &HTTPSigner{KeyID: "k1", Key: key, ContentDigest: DigestSha256}
+	client := &http.Client{Transport: signer.Transport(nil)}
+	post := func(c *http.Client, body []byte) *http.Response {
+		resp, err := c.Post(srv.URL+"/hooks?x=1", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	body := []byte(`{"event":"ping"}`)
+	resp := post(client, body)
+	echoed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(echoed, body) {
+		t.Fatalf("signed request: %s %q", resp.Status, echoed)
+	}
+
+	// a body over the limit is refused before it is buffered in full
+	resp = post(client, bytes.Repeat([]byte("x"), 4096))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large body: %s", resp.Status)
+	}
+
+	resp = post(http.DefaultClient, body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned request: %s", resp.Status)
+	}
+}
+
+func TestHTTPSignatureTamperedBody(t *testing.T) {
+	secret := bytes.Repeat([]byte("s"), 32)
+	signer := &HTTPSigner{KeyID: "h1", Key: secret, ContentDigest: DigestSha512}
+	verifier := &HTTPVerifier{
+		Keys: func(string) (HTTPSignatureKey, error) {
+			return HTTPSignatureKey{Key: secret, Algorithm: HTTPSigHmacSha256}, nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodPut, "https://api.internal/v1/items/7", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	server := func(body string) *http.Request {
+		r := httptest.NewRequest(req.Method, req.URL.String(), strings.NewReader(body))
+		r.Header = req.Header.Clone()
+		return r
+	}
+
+	r := server("payload")
+	res, err := verifier.VerifyRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Algorithm != HTTPSigHmacSha256 || res.KeyID != "h1" {
+		t.Fatalf("result %+v", res)
+	}
+	if rest, _ := io.ReadAll(r.Body); string(rest) != "payload" {
+		t.Fatalf("body not readable after verification: %q", rest)
+	}
+	if _, err := verifier.VerifyRequest(server("tampered")); err == nil {
+		t.Fatal("tampered body verified")
+	}
+	verifier.MaxBodyBytes = 4
+	var tooLarge *http.MaxBytesError
+	if _, err := verifier.VerifyRequest(server("payload")); !errors.As(err, &tooLarge) {
+		t.Fatalf("body over the limit: %v", err)
+	}
+}
@@ -0,0 +1,605 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// JSON Web Encryption, https://tools.ietf.org/html/rfc7516
+
+var (
+	ErrJweMalformed = errors.New("jwe: malformed serialization")
+	ErrJweDecrypt   = errors.New("jwe: decryption failed")
+)
+
+// JWE key management algorithms, https://tools.ietf.org/html/rfc7518#section-4.1
+// and content encryption algorithms, https://tools.ietf.org/html/rfc7518#section-5.1
+const (
+	KeyAlgRSAOAEP256   = "RSA-OAEP-256"
+	KeyAlgECDHES       = "ECDH-ES"
+	KeyAlgECDHESA256KW = "ECDH-ES+A256KW"
+	KeyAlgDir          = "dir"
+	EncA256GCM         = "A256GCM"
+	EncA128CBCHS256    = "A128CBC-HS256"
+)
+
+const (
+	jweA256KWKeySize    = 32
+	jweCEKSizeA256GCM   = 32
+	jweCEKSizeA128CBCHS = 32 // 16 byte MAC key followed by 16 byte AES key
+)
+
+// JweHeader is the decoded protected header of a decrypted JWE.
+type JweHeader struct {
+	Alg string                 `json:"alg"`
+	Enc string                 `json:"enc"`
+	Kid string                 `json:"kid,omitempty"`
+	Typ string                 `json:"typ,omitempty"`
+	Cty string                 `json:"cty,omitempty"`
+	Zip string                 `json:"zip,omitempty"`
+	Epk *JWK                   `json:"epk,omitempty"`
+	Apu string                 `json:"apu,omitempty"`
+	Apv string                 `json:"apv,omitempty"`
+	Raw map[string]interface{} `json:"-"`
+}
+
+// jweParts is one recipient's view of a JWE, all members base64url encoded.
+type jweParts struct {
+	Protected    string                 `json:"protected"`
+	Header       map[string]interface{} `json:"header,omitempty"` // per-recipient unprotected header
+	EncryptedKey string                 `json:"encrypted_key,omitempty"`
+	Iv           string                 `json:"iv"`
+	Ciphertext   string                 `json:"ciphertext"`
+	Tag          string                 `json:"tag"`
+	Aad          string                 `json:"aad,omitempty"`
+}
+
+func jweCEKSize(enc string) (int, error) {
+	switch enc {
+	case EncA256GCM:
+		return jweCEKSizeA256GCM, nil
+	case EncA128CBCHS256:
+		return jweCEKSizeA128CBCHS, nil
+	}
+	return 0, fmt.Errorf("jwe: unsupported enc %q", enc)
+}
+
+// jweKey unwraps a JWK, the other key types are used as is.
+func jweKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case JWK:
+		return k.Key
+	case *JWK:
+		return k.Key
+	}
+	return key
+}
+
+// jweEncrypt produces the parts of a single recipient JWE.
+func jweEncrypt(plaintext []byte, key interface{}, alg string, enc string, header map[string]interface{}) (*jweParts, error) {
+	cekSize, err := jweCEKSize(enc)
+	if err != nil {
+		return nil, err
+	}
+	protected := make(map[string]interface{}, len(header)+3)
+	for k, v := range header {
+		protected[k] = v
+	}
+	protected["alg"] = alg
+	protected["enc"] = enc
+
+	var cek, encryptedKey []byte
+	key = jweKey(key)
+	switch alg {
+	case KeyAlgRSAOAEP256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, ErrKeyAlgorithmMismatch
+		}
+		cek = make([]byte, cekSize)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		if err != nil {
+			return nil, err
+		}
+	case KeyAlgECDHES, KeyAlgECDHESA256KW:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, ErrKeyAlgorithmMismatch
+		}
+		remote, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := remote.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		epk, err := ecdhToECDSA(ephemeral.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		protected["epk"] = JWK{Key: epk}
+		z, err := ephemeral.ECDH(remote)
+		if err != nil {
+			return nil, err
+		}
+		apu, apv, err := jweParty(protected)
+		if err != nil {
+			return nil, err
+		}
+		if alg == KeyAlgECDHES {
+			cek = concatKDF(z, enc, apu, apv, cekSize)
+		} else {
+			kek := concatKDF(z, alg, apu, apv, jweA256KWKeySize)
+			cek = make([]byte, cekSize)
+			if _, err := rand.Read(cek); err != nil {
+				return nil, err
+			}
+			encryptedKey, err = aesKeyWrap(kek, cek)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case KeyAlgDir:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) != cekSize {
+			return nil, fmt.Errorf("%w: dir needs a %d byte key for %s", ErrKeyAlgorithmMismatch, cekSize, enc)
+		}
+		cek = secret
+	default:
+		return nil, fmt.Errorf("jwe: unsupported alg %q", alg)
+	}
+
+	phead, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	parts := &jweParts{
+		Protected:    base64.RawURLEncoding.EncodeToString(phead),
+		EncryptedKey: base64.RawURLEncoding.EncodeToString(encryptedKey),
+	}
+	iv, ciphertext, tag, err := jweSeal(enc, cek, plaintext, []byte(parts.Protected))
+	if err != nil {
+		return nil, err
+	}
+	parts.Iv = base64.RawURLEncoding.EncodeToString(iv)
+	parts.Ciphertext = base64.RawURLEncoding.EncodeToString(ciphertext)
+	parts.Tag = base64.RawURLEncoding.EncodeToString(tag)
+	return parts, nil
+}
+
+// JweEncryptCompact encrypts plaintext for key and returns the compact serialization.
+// key is an *rsa.PublicKey (RSA-OAEP-256), *ecdsa.PublicKey (ECDH-ES, ECDH-ES+A256KW),
+// a []byte content key (dir) or a JWK holding one of those.
+func JweEncryptCompact(plaintext []byte, key interface{}, alg string, enc string, header map[string]interface{}) (string, error) {
+	p, err := jweEncrypt(plaintext, key, alg, enc, header)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{p.Protected, p.EncryptedKey, p.Iv, p.Ciphertext, p.Tag}, "."), nil
+}
+
+// JweEncryptJSON is JweEncryptCompact with the flattened JSON serialization.
+func JweEncryptJSON(plaintext []byte, key interface{}, alg string, enc string, header map[string]interface{}) ([]byte, error) {
+	p, err := jweEncrypt(plaintext, key, alg, enc, header)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// parseJwe reads the compact, flattened or general JSON serialization
+// and returns one jweParts per recipient.
+func parseJwe(data []byte) ([]jweParts, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var raw struct {
+			jweParts
+			Recipients []struct {
+				Header       map[string]interface{} `json:"header"`
+				EncryptedKey string                 `json:"encrypted_key"`
+			} `json:"recipients"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrJweMalformed, err)
+		}
+		if raw.Recipients == nil {
+			return []jweParts{raw.jweParts}, nil
+		}
+		list := make([]jweParts, len(raw.Recipients))
+		for i, r := range raw.Recipients {
+			list[i] = raw.jweParts
+			list[i].Header = r.Header
+			list[i].EncryptedKey = r.EncryptedKey
+		}
+		return list, nil
+	}
+	parts := strings.Split(string(data), ".")
+	if len(parts) != 5 {
+		return nil, ErrJweMalformed
+	}
+	return []jweParts{{Protected: parts[0], EncryptedKey: parts[1], Iv: parts[2], Ciphertext: parts[3], Tag: parts[4]}}, nil
+}
+
+// JweDecrypt decrypts a JWE in any serialization with the private key
+// (or []byte content key for dir). Only key management algorithms in algs are accepted.
+func JweDecrypt(data []byte, key interface{}, algs []string) (*JweHeader, []byte, error) {
+	if len(algs) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty algorithm allowlist", ErrUnsupportedAlgorithm)
+	}
+	recipients, err := parseJwe(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastErr := ErrJweDecrypt
+	for _, p := range recipients {
+		head, plaintext, err := jweDecryptParts(&p, jweKey(key), algs)
+		if err == nil {
+			return head, plaintext, nil
+		}
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+func jweDecryptParts(p *jweParts, key interface{}, algs []string) (*JweHeader, []byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(p.Protected)
+	if err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+	head := &JweHeader{}
+	// "null" unmarshals without error, the header must be an object
+	if err := json.Unmarshal(raw, &head.Raw); err != nil || head.Raw == nil {
+		return nil, nil, ErrJweMalformed
+	}
+	// General JSON usually carries alg and epk in the per-recipient header.
+	for k, v := range p.Header {
+		if _, dup := head.Raw[k]; dup {
+			return nil, nil, fmt.Errorf("%w: header member %q is both protected and unprotected", ErrJweMalformed, k)
+		}
+		head.Raw[k] = v
+	}
+	if len(p.Header) > 0 {
+		if raw, err = json.Marshal(head.Raw); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := json.Unmarshal(raw, head); err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+	if !algAllowed(head.Alg, algs) {
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, head.Alg)
+	}
+	if head.Zip != "" || head.Raw["crit"] != nil {
+		return nil, nil, fmt.Errorf("%w: zip and crit are not supported", ErrJweMalformed)
+	}
+	cekSize, err := jweCEKSize(head.Enc)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptedKey, err := base64.RawURLEncoding.DecodeString(p.EncryptedKey)
+	if err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+
+	var cek []byte
+	switch head.Alg {
+	case KeyAlgRSAOAEP256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, ErrKeyAlgorithmMismatch
+		}
+		cek, err = rsa.DecryptOAEP(sha256.New(), nil, priv, encryptedKey, nil)
+		if err != nil || len(cek) != cekSize {
+			// carry on with a random CEK so a bad key looks like a bad tag
+			// https://tools.ietf.org/html/rfc7516#section-11.5
+			cek = make([]byte, cekSize)
+			rand.Read(cek)
+		}
+	case KeyAlgECDHES, KeyAlgECDHESA256KW:
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, nil, ErrKeyAlgorithmMismatch
+		}
+		if head.Epk == nil {
+			return nil, nil, fmt.Errorf("%w: missing epk", ErrJweMalformed)
+		}
+		epk, ok := head.Epk.Key.(*ecdsa.PublicKey)
+		if !ok || epk.Curve != priv.Curve {
+			return nil, nil, fmt.Errorf("%w: epk does not match key curve", ErrJweMalformed)
+		}
+		local, err := priv.ECDH()
+		if err != nil {
+			return nil, nil, err
+		}
+		remote, err := epk.ECDH()
+		if err != nil {
+			return nil, nil, err
+		}
+		z, err := local.ECDH(remote)
+		if err != nil {
+			return nil, nil, err
+		}
+		apu, apv, err := jweParty(head.Raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if head.Alg == KeyAlgECDHES {
+			if len(encryptedKey) != 0 {
+				return nil, nil, ErrJweMalformed
+			}
+			cek = concatKDF(z, head.Enc, apu, apv, cekSize)
+		} else {
+			kek := concatKDF(z, head.Alg, apu, apv, jweA256KWKeySize)
+			cek, err = aesKeyUnwrap(kek, encryptedKey)
+			if err != nil || len(cek) != cekSize {
+				return nil, nil, ErrJweDecrypt
+			}
+		}
+	case KeyAlgDir:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) != cekSize || len(encryptedKey) != 0 {
+			return nil, nil, ErrKeyAlgorithmMismatch
+		}
+		cek = secret
+	default:
+		return nil, nil, fmt.Errorf("jwe: unsupported alg %q", head.Alg)
+	}
+
+	iv, err := base64.RawURLEncoding.DecodeString(p.Iv)
+	if err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(p.Ciphertext)
+	if err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(p.Tag)
+	if err != nil {
+		return nil, nil, ErrJweMalformed
+	}
+	aad := p.Protected
+	if p.Aad != "" {
+		aad += "." + p.Aad
+	}
+	plaintext, err := jweOpen(head.Enc, cek, iv, ciphertext, tag, []byte(aad))
+	if err != nil {
+		return nil, nil, err
+	}
+	return head, plaintext, nil
+}
+
+// jweParty returns the decoded apu and apv header members.
+func jweParty(header map[string]interface{}) ([]byte, []byte, error) {
+	var out [2][]byte
+	for i, name := range []string{"apu", "apv"} {
+		v, ok := header[name].(string)
+		if !ok {
+			continue
+		}
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return nil, nil, ErrJweMalformed
+		}
+		out[i] = b
+	}
+	return out[0], out[1], nil
+}
+
+func ecdhToECDSA(pub *ecdh.PublicKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch pub.Curve() {
+	case ecdh.P256():
+		curve = elliptic.P256()
+	case ecdh.P384():
+		curve = elliptic.P384()
+	case ecdh.P521():
+		curve = elliptic.P521()
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	raw := pub.Bytes() // uncompressed point 0x04||X||Y
+	size := (len(raw) - 1) / 2
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(raw[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(raw[1+size:]),
+	}, nil
+}
+
+// concatKDF is the NIST SP 800-56A Concat KDF with SHA-256 as profiled by JWA.
+// https://tools.ietf.org/html/rfc7518#section-4.6.2
+func concatKDF(z []byte, algID string, apu []byte, apv []byte, size int) []byte {
+	lenPrefixed := func(b []byte) []byte {
+		out := make([]byte, 4+len(b))
+		binary.BigEndian.PutUint32(out, uint32(len(b)))
+		copy(out[4:], b)
+		return out
+	}
+	var other []byte
+	other = append(other, lenPrefixed([]byte(algID))...)
+	other = append(other, lenPrefixed(apu)...)
+	other = append(other, lenPrefixed(apv)...)
+	other = binary.BigEndian.AppendUint32(other, uint32(size*8))
+
+	var out []byte
+	for counter := uint32(1); len(out) < size; counter++ {
+		h := sha256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(other)
+		out = h.Sum(out)
+	}
+	return out[:size]
+}
+
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap implements RFC 3394 key wrapping.
+func aesKeyWrap(kek []byte, cek []byte) ([]byte, error) {
+	if len(cek)%8 != 0 || len(cek) < 16 {
+		return nil, errors.New("jwe: key to wrap must be a multiple of 8 bytes")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(cek) / 8
+	r := make([]byte, len(cek))
+	copy(r, cek)
+	a := make([]byte, 8)
+	copy(a, aesKeyWrapIV)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Encrypt(buf, buf)
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf[:8])^t)
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// aesKeyUnwrap reverses aesKeyWrap and checks the integrity value.
+func aesKeyUnwrap(kek []byte, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, ErrJweDecrypt
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, len(wrapped)-8)
+	copy(r, wrapped[8:])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[i*8:i*8+8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, aesKeyWrapIV) != 1 {
+		return nil, ErrJweDecrypt
+	}
+	return r, nil
+}
+
+// jweSeal encrypts with the content encryption algorithm enc.
+func jweSeal(enc string, cek []byte, plaintext []byte, aad []byte) ([]byte, []byte, []byte, error) {
+	switch enc {
+	case EncA256GCM:
+		gcm, err := newGCM(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		out := gcm.Seal(nil, iv, plaintext, aad)
+		split := len(out) - gcm.Overhead()
+		return iv, out[:split], out[split:], nil
+	case EncA128CBCHS256:
+		// https://tools.ietf.org/html/rfc7518#section-5.2.2.1
+		macKey, encKey := cek[:16], cek[16:]
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+		ciphertext := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		return iv, ciphertext, cbcHmacTag(macKey, aad, iv, ciphertext), nil
+	}
+	return nil, nil, nil, fmt.Errorf("jwe: unsupported enc %q", enc)
+}
+
+// jweOpen authenticates and decrypts with the content encryption algorithm enc.
+func jweOpen(enc string, cek []byte, iv []byte, ciphertext []byte, tag []byte, aad []byte) ([]byte, error) {
+	switch enc {
+	case EncA256GCM:
+		gcm, err := newGCM(cek)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+			return nil, ErrJweDecrypt
+		}
+		plaintext, err := gcm.Open(nil, iv, append(append([]byte{}, ciphertext...), tag...), aad)
+		if err != nil {
+			return nil, ErrJweDecrypt
+		}
+		return plaintext, nil
+	case EncA128CBCHS256:
+		macKey, encKey := cek[:16], cek[16:]
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, ErrJweDecrypt
+		}
+		// check the tag before touching the padding
+		if !hmac.Equal(cbcHmacTag(macKey, aad, iv, ciphertext), tag) {
+			return nil, ErrJweDecrypt
+		}
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		pad := int(plaintext[len(plaintext)-1])
+		if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+			return nil, ErrJweDecrypt
+		}
+		return plaintext[:len(plaintext)-pad], nil
+	}
+	return nil, fmt.Errorf("jwe: unsupported enc %q", enc)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cbcHmacTag is the truncated HMAC-SHA-256 tag of AES_CBC_HMAC_SHA2.
+func cbcHmacTag(macKey []byte, aad []byte, iv []byte, ciphertext []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+	input := make([]byte, 0, len(aad)+len(iv)+len(ciphertext)+8)
+	input = append(append(append(append(input, aad...), iv...), ciphertext...), al...)
+	return hmacSum(sha256.New, input, macKey)[:16]
+}
@@ -0,0 +1,171 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// https://tools.ietf.org/html/rfc7518#appendix-B.1
+func TestJweA128CBCHS256RFC7518(t *testing.T) {
+	cek := unhex(t, "000102030405060708090a0b0c0d0e0f 101112131415161718191a1b1c1d1e1f")
+	plaintext := []byte("A cipher system must not be required to be secret, and it must be able to fall into the hands of the enemy without inconvenience")
+	iv := unhex(t, "1af38c2dc2b96ffdd86694092341bc04")
+	aad := []byte("The second principle of Auguste Kerckhoffs")
+	ciphertext := unhex(t, "c80edfa32ddf39d5ef00c0b468834279 a2e46a1b8049f792f76bfe54b903a9c9"+
+		"a94ac9b47ad2655c5f10f9aef71427e2 fc6f9b3f399a221489f16362c7032336"+
+		"09d45ac69864e3321cf82935ac4096c8 6e133314c54019e8ca7980dfa4b9cf1b"+
+		"384c486f3a54c51078158ee5d79de59f bd34d848b3d69550a67646344427ade5"+
+		"4b8851ffb598f7f80074b9473c82e2db")
+	tag := unhex(t, "652c3fa36b0a7c5b3219fab3a30bc1c4")
+
+	if got := cbcHmacTag(cek[:16], aad, iv, ciphertext); !bytes.Equal(got, tag) {
+		t.Fatalf("tag %x, want %x", got, tag)
+	}
+	got, err := jweOpen(EncA128CBCHS256, cek, iv, ciphertext, tag, aad)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("open: %q %v", got, err)
+	}
+	bad := append([]byte{}, tag...)
+	bad[0] ^= 1
+	if _, err := jweOpen(EncA128CBCHS256, cek, iv, ciphertext, bad, aad); !errors.Is(err, ErrJweDecrypt) {
+		t.Fatalf("bad tag: %v", err)
+	}
+	if _, err := jweOpen(EncA128CBCHS256, cek, iv, ciphertext[:len(ciphertext)-16], tag, aad); !errors.Is(err, ErrJweDecrypt) {
+		t.Fatalf("truncated ciphertext: %v", err)
+	}
+}
+
+// https://tools.ietf.org/html/rfc7518#appendix-C
+func TestJweECDHESRFC7518(t *testing.T) {
+	alice := parseTestJWK(t, `{"kty":"EC","crv":"P-256","x":"gI0GAILBdu7T53akrFmMyGcsF3n5dO7MmwNBHKW5SV0","y":"SLW_xSffzlPWrHEVI30DHM_4egVwt3NQqeUD7nMFpps","d":"0_NxaRPUMQoAJt50Gz8YiTr8gRTwyEaCumd-MToTmIo"}`)
+	bob := parseTestJWK(t, `{"kty":"EC","crv":"P-256","x":"weNJy2HscCSM6AEDTDg04biOvhFhyyWvOHQfeF_PxMQ","y":"e8lnCO-AlStT-NJVX-crhB7QRYhiix03illJOVAOyck","d":"VEmDZpDXXK8p8N0Cndsxs924q6nS1RXFASRl6BfUqdw"}`)
+	local, err := alice.Key.(*ecdsa.PrivateKey).ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := bob.Key.(*ecdsa.PrivateKey).PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := local.ECDH(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "9e56d91d817135d372834283bf84269cfb316ea3da806a48f6daa7798cfe90c4"); !bytes.Equal(z, want) {
+		t.Fatalf("Z %x", z)
+	}
+	if got := b64(concatKDF(z, "A128GCM", []byte("Alice"), []byte("Bob"), 16)); got != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Fatalf("derived key %s", got)
+	}
+}
+
+// https://tools.ietf.org/html/rfc3394#section-4
+func TestAESKeyWrapRFC3394(t *testing.T) {
+	for _, tc := range []struct{ kek, key, wrapped string }{
+		{"000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF", "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+	} {
+		kek, key, wrapped := unhex(t, tc.kek), unhex(t, tc.key), unhex(t, tc.wrapped)
+		got, err := aesKeyWrap(kek, key)
+		if err != nil || !bytes.Equal(got, wrapped) {
+			t.Fatalf("wrap: %X %v", got, err)
+		}
+		back, err := aesKeyUnwrap(kek, wrapped)
+		if err != nil || !bytes.Equal(back, key) {
+			t.Fatalf("unwrap: %X %v", back, err)
+		}
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := aesKeyUnwrap(kek, wrapped); !errors.Is(err, ErrJweDecrypt) {
+			t.Fatalf("tampered unwrap: %v", err)
+		}
+	}
+}
+
+func TestJweRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirKey := make([]byte, 32)
+	rand.Read(dirKey)
+	plaintext := []byte("secret message")
+	for _, tc := range []struct {
+		alg      string
+		enc, dec interface{}
+	}{
+		{KeyAlgRSAOAEP256, &rsaKey.PublicKey, rsaKey},
+		{KeyAlgECDHES, &ecKey.PublicKey, ecKey},
+		{KeyAlgECDHESA256KW, JWK{Key: &ecKey.PublicKey}, JWK{Key: ecKey}},
+		{KeyAlgDir, dirKey, dirKey},
+	} {
+		for _, enc := range []string{EncA256GCM, EncA128CBCHS256} {
+			name := tc.alg + "/" + enc
+			compact, err := JweEncryptCompact(plaintext, tc.enc, tc.alg, enc, map[string]interface{}{"kid": "k1", "apu": b64([]byte("me"))})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			flattened, err := JweEncryptJSON(plaintext, tc.enc, tc.alg, enc, nil)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			for _, data := range [][]byte{[]byte(compact), flattened} {
+				head, got, err := JweDecrypt(data, tc.dec, []string{tc.alg})
+				if err != nil || !bytes.Equal(got, plaintext) || head.Enc != enc {
+					t.Fatalf("%s: %q %v", name, got, err)
+				}
+			}
+			if _, _, err := JweDecrypt([]byte(compact), tc.dec, []string{"other"}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+				t.Fatalf("%s not allowed: %v", name, err)
+			}
+
+			parts := strings.Split(compact, ".")
+			// flipping a ciphertext, tag or header bit must fail authentication
+			for _, i := range []int{0, 3, 4} {
+				tampered := append([]string{}, parts...)
+				b := b64Decode(t, tampered[i])
+				b[len(b)-1] ^= 1
+				tampered[i] = b64(b)
+				if _, got, err := JweDecrypt([]byte(strings.Join(tampered, ".")), tc.dec, []string{tc.alg}); err == nil || got != nil {
+					t.Fatalf("%s: tampered part %d decrypted", name, i)
+				}
+			}
+			if _, _, err := JweDecrypt([]byte(strings.Join(parts[:4], ".")), tc.dec, []string{tc.alg}); !errors.Is(err, ErrJweMalformed) {
+				t.Fatalf("%s: truncated: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestJweDecryptRejectsHeaders(t *testing.T) {
+	key := make([]byte, 32)
+	compact, err := JweEncryptCompact([]byte("x"), key, KeyAlgDir, EncA256GCM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := compact[strings.Index(compact, "."):]
+	for _, head := range []string{`null`, `[]`, `{"alg":"dir","enc":"A256GCM","zip":"DEF"}`, `{"alg":"dir","enc":"A256GCM","crit":["x"]}`} {
+		if _, _, err := JweDecrypt([]byte(b64([]byte(head))+rest), key, []string{KeyAlgDir}); err == nil {
+			t.Fatalf("header %s accepted", head)
+		}
+	}
+}
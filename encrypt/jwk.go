@@ -0,0 +1,333 @@
+package encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON Web Key holding an RSA, EC or OKP (Ed25519) key,
+// either the public part only or the full private key.
+// https://tools.ietf.org/html/rfc7517
+type JWK struct {
+	// *rsa.PublicKey, *rsa.PrivateKey, *ecdsa.PublicKey, *ecdsa.PrivateKey,
+	// ed25519.PublicKey or ed25519.PrivateKey
+	Key       interface{}
+	KeyID     string
+	Use       string // "sig" or "enc"
+	Algorithm string
+}
+
+// JWKSet is a JWK Set document, https://tools.ietf.org/html/rfc7517#section-5
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwkJSON lists the members we read and write for every key type.
+type jwkJSON struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	// RSA https://tools.ietf.org/html/rfc7518#section-6.3
+	N  string `json:"n,omitempty"`
+	E  string `json:"e,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	Dp string `json:"dp,omitempty"`
+	Dq string `json:"dq,omitempty"`
+	Qi string `json:"qi,omitempty"`
+	// EC and OKP
+	X string `json:"x,omitempty"`
+	Y string `json:"y,omitempty"`
+	// private exponent / scalar / seed
+	D string `json:"d,omitempty"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// b64Fixed encodes n left padded to size bytes, as EC coordinates must be.
+func b64Fixed(n *big.Int, size int) string {
+	return b64(n.FillBytes(make([]byte, size)))
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k JWK) MarshalJSON() ([]byte, error) {
+	out := jwkJSON{Kid: k.KeyID, Use: k.Use, Alg: k.Algorithm}
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, errors.New("jwk: multi-prime RSA keys are not supported")
+		}
+		// CRT values computed here, Precompute would modify the caller's key
+		p, q := key.Primes[0], key.Primes[1]
+		one := big.NewInt(1)
+		dp := new(big.Int).Mod(key.D, new(big.Int).Sub(p, one))
+		dq := new(big.Int).Mod(key.D, new(big.Int).Sub(q, one))
+		qi := new(big.Int).ModInverse(q, p)
+		if qi == nil {
+			return nil, errors.New("jwk: invalid RSA key")
+		}
+		out.D = b64(key.D.Bytes())
+		out.P = b64(p.Bytes())
+		out.Q = b64(q.Bytes())
+		out.Dp = b64(dp.Bytes())
+		out.Dq = b64(dq.Bytes())
+		out.Qi = b64(qi.Bytes())
+		fillRSAPublic(&out, &key.PublicKey)
+	case *rsa.PublicKey:
+		fillRSAPublic(&out, key)
+	case *ecdsa.PrivateKey:
+		if err := fillECPublic(&out, &key.PublicKey); err != nil {
+			return nil, err
+		}
+		out.D = b64Fixed(key.D, (key.Curve.Params().BitSize+7)/8)
+	case *ecdsa.PublicKey:
+		if err := fillECPublic(&out, key); err != nil {
+			return nil, err
+		}
+	case ed25519.PrivateKey:
+		// https://tools.ietf.org/html/rfc8037#section-2
+		out.Kty, out.Crv = "OKP", "Ed25519"
+		out.X = b64(key.Public().(ed25519.PublicKey))
+		out.D = b64(key.Seed())
+	case ed25519.PublicKey:
+		out.Kty, out.Crv = "OKP", "Ed25519"
+		out.X = b64(key)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return json.Marshal(&out)
+}
+
+func fillRSAPublic(out *jwkJSON, pub *rsa.PublicKey) {
+	out.Kty = "RSA"
+	out.N = b64(pub.N.Bytes())
+	out.E = b64(big.NewInt(int64(pub.E)).Bytes())
+}
+
+func fillECPublic(out *jwkJSON, pub *ecdsa.PublicKey) error {
+	p := pub.Curve.Params()
+	switch p.Name {
+	case "P-256", "P-384", "P-521":
+	default:
+		return ErrUnsupportedKey
+	}
+	size := (p.BitSize + 7) / 8
+	out.Kty, out.Crv = "EC", p.Name
+	out.X = b64Fixed(pub.X, size)
+	out.Y = b64Fixed(pub.Y, size)
+	return nil
+}
+
+func (k *JWK) UnmarshalJSON(data []byte) error {
+	var in jwkJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	var err error
+	switch in.Kty {
+	case "RSA":
+		k.Key, err = parseRSAJWK(&in)
+	case "EC":
+		k.Key, err = parseECJWK(&in)
+	case "OKP":
+		k.Key, err = parseOKPJWK(&in)
+	default:
+		err = fmt.Errorf("%w: kty %q", ErrUnsupportedKey, in.Kty)
+	}
+	if err != nil {
+		return err
+	}
+	k.KeyID, k.Use, k.Algorithm = in.Kid, in.Use, in.Alg
+	return nil
+}
+
+func parseRSAJWK(in *jwkJSON) (interface{}, error) {
+	n, err := b64Int(in.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := b64Int(in.E)
+	if err != nil {
+		return nil, err
+	}
+	if n.Sign() == 0 || e.Sign() == 0 || e.BitLen() > 31 {
+		return nil, errors.New("jwk: invalid RSA public key")
+	}
+	pub := rsa.PublicKey{N: n, E: int(e.Int64())}
+	if in.D == "" {
+		return &pub, nil
+	}
+	d, err := b64Int(in.D)
+	if err != nil {
+		return nil, err
+	}
+	p, err := b64Int(in.P)
+	if err != nil {
+		return nil, err
+	}
+	q, err := b64Int(in.Q)
+	if err != nil {
+		return nil, err
+	}
+	key := &rsa.PrivateKey{PublicKey: pub, D: d, Primes: []*big.Int{p, q}}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	key.Precompute()
+	return key, nil
+}
+
+func parseECJWK(in *jwkJSON) (interface{}, error) {
+	var curve elliptic.Curve
+	switch in.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("%w: crv %q", ErrUnsupportedKey, in.Crv)
+	}
+	x, err := b64Int(in.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := b64Int(in.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("jwk: EC point is not on curve")
+	}
+	pub := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if in.D == "" {
+		return &pub, nil
+	}
+	d, err := b64Int(in.D)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PrivateKey{PublicKey: pub, D: d}
+	if x2, y2 := curve.ScalarBaseMult(d.Bytes()); x2.Cmp(x) != 0 || y2.Cmp(y) != 0 {
+		return nil, errors.New("jwk: EC private key does not match public key")
+	}
+	return key, nil
+}
+
+func parseOKPJWK(in *jwkJSON) (interface{}, error) {
+	if in.Crv != "Ed25519" {
+		return nil, fmt.Errorf("%w: crv %q", ErrUnsupportedKey, in.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(in.X)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("jwk: invalid Ed25519 public key")
+	}
+	if in.D == "" {
+		return ed25519.PublicKey(x), nil
+	}
+	seed, err := base64.RawURLEncoding.DecodeString(in.D)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("jwk: invalid Ed25519 private key")
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	if !key.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		return nil, errors.New("jwk: Ed25519 private key does not match public key")
+	}
+	return key, nil
+}
+
+// Public returns a copy of k that only carries the public key.
+func (k JWK) Public() JWK {
+	if signer, ok := k.Key.(crypto.Signer); ok {
+		k.Key = signer.Public()
+	}
+	return k
+}
+
+// IsPrivate reports whether k holds a private key.
+func (k JWK) IsPrivate() bool {
+	_, ok := k.Key.(crypto.Signer)
+	return ok
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the public key.
+func (k JWK) Thumbprint() (string, error) {
+	return JWKThumbprint(k.Public().Key)
+}
+
+// Lookup returns the first key with the given kid.
+func (s JWKSet) Lookup(kid string) (JWK, bool) {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k, true
+		}
+	}
+	return JWK{}, false
+}
+
+// Public returns the set with every private key reduced to its public part.
+func (s JWKSet) Public() JWKSet {
+	out := JWKSet{Keys: make([]JWK, len(s.Keys))}
+	for i, k := range s.Keys {
+		out.Keys[i] = k.Public()
+	}
+	return out
+}
+
+// UnmarshalJSON skips keys of unknown type instead of failing the whole set.
+// https://tools.ietf.org/html/rfc7517#section-5
+func (s *JWKSet) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	s.Keys = s.Keys[:0]
+	for _, r := range raw.Keys {
+		var k JWK
+		if err := k.UnmarshalJSON(r); err != nil {
+			if errors.Is(err, ErrUnsupportedKey) {
+				continue
+			}
+			return err
+		}
+		s.Keys = append(s.Keys, k)
+	}
+	return nil
+}
+
+// jwkDecode parses the public key out of a JWK.
+func jwkDecode(raw []byte) (crypto.PublicKey, error) {
+	var k JWK
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, err
+	}
+	return k.Public().Key, nil
+}
@@ -0,0 +1,182 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// JWKSHandler publishes the public part of keys() as a JWK Set document.
+// keys is called on every request so rotated keys show up immediately.
+func JWKSHandler(keys func() JWKSet, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := json.Marshal(keys().Public())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+		w.Write(body)
+	})
+}
+
+// JWKSCache fetches a remote JWK Set and keeps it for as long as the
+// Cache-Control header allows. An unknown kid triggers a refresh, but at
+// most once every MinRefreshInterval so bogus kids can't hammer the origin,
+// even when the origin forbids caching or is failing.
+type JWKSCache struct {
+	URL                string
+	DefaultTTL         time.Duration // used when the response has no max-age
+	MinRefreshInterval time.Duration
+	FetchTimeout       time.Duration // bounds each fetch, 10 seconds when zero
+	Client             *resty.Client
+
+	mu       sync.Mutex
+	set      JWKSet
+	err      error // of the last refresh
+	expires  time.Time
+	fetched  time.Time
+	inflight chan struct{} // closed when the running refresh is done
+}
+
+const defaultJWKSFetchTimeout = 10 * time.Second
+
+func NewJWKSCache(url string) *JWKSCache {
+	return &JWKSCache{
+		URL:                url,
+		DefaultTTL:         5 * time.Minute,
+		MinRefreshInterval: 30 * time.Second,
+		FetchTimeout:       defaultJWKSFetchTimeout,
+		Client:             resty.New(),
+	}
+}
+
+// Key returns the key for kid, fetching the set when it is stale or kid is
+// unknown. A stale set is served when the refresh fails.
+func (c *JWKSCache) Key(ctx context.Context, kid string) (JWK, error) {
+	set, err := c.get(ctx, func(set JWKSet) bool {
+		_, ok := set.Lookup(kid)
+		return ok
+	})
+	if k, ok := set.Lookup(kid); ok {
+		return k, nil
+	}
+	if err != nil {
+		return JWK{}, err
+	}
+	return JWK{}, fmt.Errorf("jwks: unknown kid %q", kid)
+}
+
+// Set returns the cached set, fetching it if it is stale.
+func (c *JWKSCache) Set(ctx context.Context) (JWKSet, error) {
+	return c.get(ctx, nil)
+}
+
+// get returns the cached set, refetching it when it has expired or when has
+// is set and reports the wanted key missing. The origin is asked at most once
+// every MinRefreshInterval and concurrent callers share one fetch, which runs
+// on its own context so a caller giving up doesn't fail it for the others.
+func (c *JWKSCache) get(ctx context.Context, has func(JWKSet) bool) (JWKSet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.inflight != nil {
+		if err := c.wait(ctx); err != nil {
+			return c.set, err
+		}
+	}
+	now := time.Now()
+	if now.Before(c.expires) && (has == nil || has(c.set)) {
+		return c.set, nil
+	}
+	if now.Sub(c.fetched) < c.MinRefreshInterval {
+		return c.set, c.err
+	}
+	c.inflight = make(chan struct{})
+	c.fetched = now
+	go c.refresh(c.inflight, now)
+	if err := c.wait(ctx); err != nil {
+		return c.set, err
+	}
+	return c.set, c.err
+}
+
+// wait releases c.mu until the running fetch is done or ctx ends, and
+// returns with c.mu held again.
+func (c *JWKSCache) wait(ctx context.Context) error {
+	done := c.inflight
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refresh fetches the set and closes done, a failed fetch keeps the old set.
+func (c *JWKSCache) refresh(done chan struct{}, started time.Time) {
+	timeout := c.FetchTimeout
+	if timeout <= 0 {
+		timeout = defaultJWKSFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	set, ttl, err := c.fetch(ctx)
+	cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.set = set
+		c.expires = started.Add(ttl)
+	}
+	c.err = err
+	c.inflight = nil
+	close(done)
+}
+
+// fetch downloads the set, c.mu must not be held.
+func (c *JWKSCache) fetch(ctx context.Context) (JWKSet, time.Duration, error) {
+	resp, err := c.Client.R().SetContext(ctx).Get(c.URL)
+	if err != nil {
+		return JWKSet{}, 0, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return JWKSet{}, 0, fmt.Errorf("jwks: fetching %s: %s", c.URL, resp.Status())
+	}
+	var set JWKSet
+	if err := json.Unmarshal(resp.Body(), &set); err != nil {
+		return JWKSet{}, 0, err
+	}
+	return set, cacheTTL(resp.Header().Get("Cache-Control"), c.DefaultTTL), nil
+}
+
+// cacheTTL reads max-age from a Cache-Control header,
+// no-store and no-cache mean the response must not be reused.
+func cacheTTL(header string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			sec, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && sec >= 0 {
+				return time.Duration(sec) * time.Second
+			}
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,137 @@
+package encrypt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestJWKS(t *testing.T) JWKSet {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return JWKSet{Keys: []JWK{{Key: key, KeyID: "k1"}}}
+}
+
+func TestJWKSCacheThrottle(t *testing.T) {
+	set := newTestJWKS(t)
+	var fetches int32
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		// the origin forbids caching, the refresh interval still applies
+		w.Header().Set("Cache-Control", "no-store")
+		JWKSHandler(func() JWKSet { return set }, 0).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cache := NewJWKSCache(srv.URL)
+	cache.MinRefreshInterval = time.Hour
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Key(context.Background(), "k1"); err != nil {
+				t.Error(err)
+			}
+			cache.Key(context.Background(), "unknown")
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+
+	// past the interval a failing origin leaves the old set in use
+	failing.Store(true)
+	cache.MinRefreshInterval = 0
+	if _, err := cache.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("stale key not served: %v", err)
+	}
+	if _, err := cache.Key(context.Background(), "unknown"); err == nil {
+		t.Fatal("unknown kid found")
+	}
+}
+
+func TestJWKSCacheCancelledCaller(t *testing.T) {
+	set := newTestJWKS(t)
+	release := make(chan struct{})
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		JWKSHandler(func() JWKSet { return set }, time.Minute).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cache := NewJWKSCache(srv.URL)
+
+	// the caller that starts the fetch gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cache.Key(ctx, "k1")
+		first <- err
+	}()
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error)
+	go func() {
+		_, err := cache.Key(context.Background(), "k1")
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller: %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller: %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("%d fetches, want 1", n)
+	}
+}
+
+func TestJWKMarshalRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Precomputed = rsa.PrecomputedValues{}
+	data, err := json.Marshal(JWK{Key: key, KeyID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Precomputed.Dp != nil {
+		t.Fatal("MarshalJSON modified the key")
+	}
+	var back JWK
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	priv, ok := back.Key.(*rsa.PrivateKey)
+	if !ok || !priv.Equal(key) || back.KeyID != "r1" {
+		t.Fatalf("round trip: %T %v", back.Key, back.KeyID)
+	}
+	key.Precompute()
+	if priv.Precomputed.Dp.Cmp(key.Precomputed.Dp) != 0 || priv.Precomputed.Qinv.Cmp(key.Precomputed.Qinv) != 0 {
+		t.Fatal("CRT values differ")
+	}
+}
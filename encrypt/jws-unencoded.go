@@ -0,0 +1,183 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Unencoded payload option, https://tools.ietf.org/html/rfc7797
+// With "b64":false the payload goes into the signing input as is, so large
+// bodies are streamed into the hash instead of being encoded and buffered.
+// Combined with a detached payload (https://tools.ietf.org/html/rfc7515#appendix-F)
+// the JWS is just "header..signature" and travels next to the body,
+// e.g. in an HTTP header.
+
+// jwsSigningKey splits key into a signer or HMAC secret and checks it fits alg.
+func jwsSigningKey(key interface{}, alg string) (crypto.Signer, []byte, error) {
+	switch key := key.(type) {
+	case string:
+		return nil, []byte(key), CheckKeyAlgorithm([]byte(key), alg)
+	case []byte:
+		return nil, key, CheckKeyAlgorithm(key, alg)
+	case crypto.Signer:
+		return key, nil, CheckKeyAlgorithm(key.Public(), alg)
+	}
+	return nil, nil, ErrUnsupportedKey
+}
+
+// jwsUnencodedHeader returns the encoded protected header for alg with "b64":false.
+func jwsUnencodedHeader(header map[string]interface{}, alg string) (string, error) {
+	protected := make(map[string]interface{}, len(header)+3)
+	for k, v := range header {
+		protected[k] = v
+	}
+	protected["alg"] = alg
+	protected["b64"] = false
+	protected["crit"] = []string{"b64"}
+	phead, err := json.Marshal(protected)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(phead), nil
+}
+
+// jwsSignStream signs protected + "." + payload, reading payload once.
+func jwsSignStream(key interface{}, alg string, protected string, payload io.Reader) ([]byte, error) {
+	signer, secret, err := jwsSigningKey(key, alg)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newJwsDigest(alg, secret)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(d, protected+".")
+	if _, err := io.Copy(d, payload); err != nil {
+		return nil, err
+	}
+	return d.sign(signer)
+}
+
+// JwsSignUnencoded signs payload with "b64":false and returns the flattened
+// JSON serialization carrying payload as is, so it must be valid UTF-8.
+// key is the []byte (or string) secret for HS* algorithms or a crypto.Signer.
+func JwsSignUnencoded(payload []byte, key interface{}, alg string, header map[string]interface{}) ([]byte, error) {
+	if !utf8.Valid(payload) {
+		return nil, errors.New("jws: unencoded payload must be valid UTF-8")
+	}
+	protected, err := jwsUnencodedHeader(header, alg)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := jwsSignStream(key, alg, protected, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	enc := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Sig       string `json:"signature"`
+	}{
+		Protected: protected,
+		Payload:   string(payload),
+		Sig:       base64.RawURLEncoding.EncodeToString(sig),
+	}
+	return json.Marshal(&enc)
+}
+
+// JwsSignDetached streams payload into a "b64":false signature and returns
+// the compact serialization without the payload, "header..signature".
+// Only EdDSA, which signs the message itself, holds the payload in memory.
+// key is the []byte (or string) secret for HS* algorithms or a crypto.Signer.
+func JwsSignDetached(payload io.Reader, key interface{}, alg string, header map[string]interface{}) (string, error) {
+	protected, err := jwsUnencodedHeader(header, alg)
+	if err != nil {
+		return "", err
+	}
+	sig, err := jwsSignStream(key, alg, protected, payload)
+	if err != nil {
+		return "", err
+	}
+	return protected + ".." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyDetached checks o against a detached payload and returns the protected
+// header of the first signature that verifies, see Verify for key and algs.
+// payload is read once and hashed as is for "b64":false, base64url encoded
+// on the fly otherwise.
+func (o *JwsObject) VerifyDetached(payload io.Reader, key interface{}, algs []string) (*JwsHeader, error) {
+	if o.Payload != "" {
+		return nil, fmt.Errorf("%w: payload is not detached", ErrJwsMalformed)
+	}
+	if len(algs) == 0 {
+		return nil, fmt.Errorf("%w: empty algorithm allowlist", ErrUnsupportedAlgorithm)
+	}
+	pub, err := jwsVerificationKey(key)
+	if err != nil {
+		return nil, err
+	}
+	heads, err := o.decodeHeaders()
+	if err != nil {
+		return nil, err
+	}
+	// every candidate signature gets its own digest, fed from a single read
+	lastErr := ErrJwsSignature
+	digests := make([]*jwsDigest, len(heads))
+	var writers []io.Writer
+	for i, head := range heads {
+		if !algAllowed(head.Alg, algs) {
+			lastErr = fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, head.Alg)
+			continue
+		}
+		d, err := newJwsVerifyDigest(pub, head.Alg)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		io.WriteString(d, o.Signatures[i].Protected+".")
+		digests[i] = d
+		writers = append(writers, d)
+	}
+	if len(writers) == 0 {
+		return nil, lastErr
+	}
+	w := io.MultiWriter(writers...)
+	if heads[0].Unencoded() {
+		_, err = io.Copy(w, payload)
+	} else {
+		enc := base64.NewEncoder(base64.RawURLEncoding, w)
+		if _, err = io.Copy(enc, payload); err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range digests {
+		if d == nil {
+			continue
+		}
+		if err := d.verify(pub, o.Signatures[i].Signature); err != nil {
+			lastErr = err
+			continue
+		}
+		return heads[i], nil
+	}
+	return nil, lastErr
+}
+
+// JwsVerifyDetached parses data, usually "header..signature", and verifies it
+// against payload, see JwsObject.VerifyDetached.
+func JwsVerifyDetached(data []byte, payload io.Reader, key interface{}, algs []string) (*JwsHeader, error) {
+	obj, err := ParseJws(data)
+	if err != nil {
+		return nil, err
+	}
+	return obj.VerifyDetached(payload, key, algs)
+}
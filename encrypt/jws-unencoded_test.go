@@ -0,0 +1,99 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// https://tools.ietf.org/html/rfc7797#section-4
+const (
+	rfc7797Payload     = "$.02"
+	rfc7797Encoded     = "eyJhbGciOiJIUzI1NiJ9.JC4wMg.5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"
+	rfc7797Unencoded   = "eyJhbGciOiJIUzI1NiIsImI2NCI6ZmFsc2UsImNyaXQiOlsiYjY0Il19..A5dxf2s96_n5FLueVuW1Z_vh161FwXZC4YLPff6dmDY"
+	rfc7797DetachedB64 = "eyJhbGciOiJIUzI1NiJ9..5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"
+)
+
+func TestJwsUnencodedRFC7797(t *testing.T) {
+	secret := b64Decode(t, rfc7515HS256Key)
+
+	// 4.1, the ordinary JWS over the same payload
+	if _, payload, err := JwsVerify([]byte(rfc7797Encoded), secret, []string{AlgHS256}); err != nil || string(payload) != rfc7797Payload {
+		t.Fatalf("4.1: %q %v", payload, err)
+	}
+
+	// 4.2, the header is serialized exactly as in the RFC
+	jws, err := JwsSignDetached(strings.NewReader(rfc7797Payload), secret, AlgHS256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jws != rfc7797Unencoded {
+		t.Fatalf("detached JWS %s, want %s", jws, rfc7797Unencoded)
+	}
+	for _, tc := range []struct{ name, jws string }{{"b64 false", rfc7797Unencoded}, {"b64 true", rfc7797DetachedB64}} {
+		head, err := JwsVerifyDetached([]byte(tc.jws), strings.NewReader(rfc7797Payload), secret, []string{AlgHS256})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if head.Alg != AlgHS256 {
+			t.Fatalf("%s: %+v", tc.name, head)
+		}
+		if _, err := JwsVerifyDetached([]byte(tc.jws), strings.NewReader("$.03"), secret, []string{AlgHS256}); !errors.Is(err, ErrJwsSignature) {
+			t.Fatalf("%s: other payload: %v", tc.name, err)
+		}
+	}
+
+	// the flattened JSON form carries the payload as is
+	flattened, err := JwsSignUnencoded([]byte(rfc7797Payload), secret, AlgHS256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(flattened, []byte(`"payload":"$.02"`)) || !bytes.Contains(flattened, []byte("A5dxf2s96_n5FLueVuW1Z_vh161FwXZC4YLPff6dmDY")) {
+		t.Fatalf("flattened %s", flattened)
+	}
+	if _, payload, err := JwsVerify(flattened, secret, []string{AlgHS256}); err != nil || string(payload) != rfc7797Payload {
+		t.Fatalf("flattened: %q %v", payload, err)
+	}
+	if _, err := JwsSignUnencoded([]byte{0xff}, secret, AlgHS256, nil); err == nil {
+		t.Fatal("invalid UTF-8 payload signed")
+	}
+}
+
+func TestJwsDetachedRejects(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := bytes.Repeat([]byte("webhook body "), 10000)
+	jws, err := JwsSignDetached(bytes.NewReader(body), key, AlgES256, map[string]interface{}{"kid": "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := JwsVerifyDetached([]byte(jws), bytes.NewReader(body), &key.PublicKey, []string{AlgES256})
+	if err != nil || head.Kid != "k1" {
+		t.Fatalf("verify: %+v %v", head, err)
+	}
+
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)/2] ^= 1
+	for _, tc := range []struct {
+		name    string
+		jws     string
+		payload []byte
+		algs    []string
+		want    error
+	}{
+		{"tampered body", jws, tampered, []string{AlgES256}, ErrJwsSignature},
+		{"truncated body", jws, body[:len(body)-1], []string{AlgES256}, ErrJwsSignature},
+		{"alg not allowed", jws, body, []string{AlgES384}, ErrUnsupportedAlgorithm},
+		{"attached payload", rfc7797Encoded, body, []string{AlgES256}, ErrJwsMalformed},
+	} {
+		if _, err := JwsVerifyDetached([]byte(tc.jws), bytes.NewReader(tc.payload), &key.PublicKey, tc.algs); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
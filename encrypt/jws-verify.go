@@ -0,0 +1,313 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrJwsMalformed is returned when the input is not a valid JWS serialization.
+	ErrJwsMalformed = errors.New("jws: malformed serialization")
+	// ErrJwsSignature is returned when no signature verifies with the given key.
+	ErrJwsSignature = errors.New("jws: signature verification failed")
+)
+
+// JwsHeader is the decoded protected header of a verified JWS.
+// Raw holds every protected member, including ones without a field here.
+type JwsHeader struct {
+	Alg   string                 `json:"alg"`
+	Kid   string                 `json:"kid,omitempty"`
+	Typ   string                 `json:"typ,omitempty"`
+	Cty   string                 `json:"cty,omitempty"`
+	Jwk   json.RawMessage        `json:"jwk,omitempty"`
+	Nonce string                 `json:"nonce,omitempty"`
+	Url   string                 `json:"url,omitempty"`
+	Crit  []string               `json:"crit,omitempty"`
+	B64   *bool                  `json:"b64,omitempty"` // https://tools.ietf.org/html/rfc7797#section-3
+	Raw   map[string]interface{} `json:"-"`
+}
+
+// Unencoded reports whether the payload is used as is rather than base64url encoded.
+func (h *JwsHeader) Unencoded() bool {
+	return h.B64 != nil && !*h.B64
+}
+
+// JwsSignature is one signature of a parsed JWS, still encoded as transmitted.
+type JwsSignature struct {
+	Protected string                 // base64url protected header
+	Header    map[string]interface{} // unprotected header, JSON serializations only
+	Signature []byte
+}
+
+// JwsObject is a parsed, not yet verified JWS.
+type JwsObject struct {
+	Payload    string // payload as transmitted, base64url unless "b64" is false
+	Signatures []JwsSignature
+}
+
+type jwsJSONSignature struct {
+	Protected string                 `json:"protected"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature"`
+}
+
+// ParseJws reads the compact, flattened JSON or general JSON serialization.
+// See https://tools.ietf.org/html/rfc7515#section-7
+func ParseJws(data []byte) (*JwsObject, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		return parseJwsJSON(data)
+	}
+	parts := strings.Split(string(data), ".")
+	if len(parts) != 3 {
+		return nil, ErrJwsMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJwsMalformed
+	}
+	return &JwsObject{
+		Payload:    parts[1],
+		Signatures: []JwsSignature{{Protected: parts[0], Signature: sig}},
+	}, nil
+}
+
+func parseJwsJSON(data []byte) (*JwsObject, error) {
+	var raw struct {
+		Payload    *string            `json:"payload"`
+		Signatures []jwsJSONSignature `json:"signatures"`
+		jwsJSONSignature
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJwsMalformed, err)
+	}
+	if raw.Payload == nil {
+		// detached content, https://tools.ietf.org/html/rfc7515#appendix-F
+		raw.Payload = new(string)
+	}
+	sigs := raw.Signatures
+	if sigs == nil {
+		// flattened syntax
+		sigs = []jwsJSONSignature{raw.jwsJSONSignature}
+	} else if raw.Protected != "" || raw.Signature != "" {
+		return nil, fmt.Errorf("%w: mixed flattened and general syntax", ErrJwsMalformed)
+	}
+	obj := &JwsObject{Payload: *raw.Payload}
+	for _, s := range sigs {
+		sig, err := base64.RawURLEncoding.DecodeString(s.Signature)
+		if err != nil {
+			return nil, ErrJwsMalformed
+		}
+		obj.Signatures = append(obj.Signatures, JwsSignature{Protected: s.Protected, Header: s.Header, Signature: sig})
+	}
+	if len(obj.Signatures) == 0 {
+		return nil, ErrJwsMalformed
+	}
+	return obj, nil
+}
+
+// decodeHeader decodes and sanity checks the protected header of a signature.
+func (s *JwsSignature) decodeHeader() (*JwsHeader, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s.Protected)
+	if err != nil {
+		return nil, ErrJwsMalformed
+	}
+	head := &JwsHeader{}
+	if err := json.Unmarshal(raw, head); err != nil {
+		return nil, ErrJwsMalformed
+	}
+	if err := json.Unmarshal(raw, &head.Raw); err != nil {
+		return nil, ErrJwsMalformed
+	}
+	for k := range s.Header {
+		if _, dup := head.Raw[k]; dup {
+			return nil, fmt.Errorf("%w: header member %q is both protected and unprotected", ErrJwsMalformed, k)
+		}
+	}
+	// "b64" is the only extension we understand, every other critical one is rejected.
+	// https://tools.ietf.org/html/rfc7515#section-4.1.11
+	for _, c := range head.Crit {
+		if c != "b64" {
+			return nil, fmt.Errorf("%w: unsupported critical header %q", ErrJwsMalformed, c)
+		}
+		if _, ok := head.Raw[c]; !ok {
+			return nil, fmt.Errorf("%w: critical header %q is missing", ErrJwsMalformed, c)
+		}
+	}
+	// https://tools.ietf.org/html/rfc7797#section-6
+	if _, ok := s.Header["b64"]; ok {
+		return nil, fmt.Errorf("%w: \"b64\" must be protected", ErrJwsMalformed)
+	}
+	if head.B64 != nil && len(head.Crit) == 0 {
+		return nil, fmt.Errorf("%w: \"b64\" must be listed in \"crit\"", ErrJwsMalformed)
+	}
+	return head, nil
+}
+
+// decodeHeaders decodes every protected header. All signatures must agree on
+// "b64" as they share the payload, https://tools.ietf.org/html/rfc7797#section-3
+func (o *JwsObject) decodeHeaders() ([]*JwsHeader, error) {
+	heads := make([]*JwsHeader, len(o.Signatures))
+	for i := range o.Signatures {
+		head, err := o.Signatures[i].decodeHeader()
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && head.Unencoded() != heads[0].Unencoded() {
+			return nil, fmt.Errorf("%w: signatures disagree on \"b64\"", ErrJwsMalformed)
+		}
+		heads[i] = head
+	}
+	return heads, nil
+}
+
+// Verify checks the signatures against key and returns the protected header
+// and payload of the first one that verifies. Only algorithms listed in algs
+// are accepted. key is a public (or private) RSA, ECDSA or Ed25519 key, a JWK
+// (also as json.RawMessage), or the []byte secret for HS* algorithms.
+// Unencoded payloads ("b64":false) are returned as transmitted.
+func (o *JwsObject) Verify(key interface{}, algs []string) (*JwsHeader, []byte, error) {
+	if len(algs) == 0 {
+		return nil, nil, fmt.Errorf("%w: empty algorithm allowlist", ErrUnsupportedAlgorithm)
+	}
+	pub, err := jwsVerificationKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	heads, err := o.decodeHeaders()
+	if err != nil {
+		return nil, nil, err
+	}
+	lastErr := ErrJwsSignature
+	for i, head := range heads {
+		sig := &o.Signatures[i]
+		if !algAllowed(head.Alg, algs) {
+			lastErr = fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, head.Alg)
+			continue
+		}
+		if err := jwsVerifySignature(pub, head.Alg, sig.Protected+"."+o.Payload, sig.Signature); err != nil {
+			lastErr = err
+			continue
+		}
+		if head.Unencoded() {
+			return head, []byte(o.Payload), nil
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(o.Payload)
+		if err != nil {
+			return nil, nil, ErrJwsMalformed
+		}
+		return head, payload, nil
+	}
+	return nil, nil, lastErr
+}
+
+// JwsVerify parses data in any JWS serialization and verifies it, see JwsObject.Verify.
+func JwsVerify(data []byte, key interface{}, algs []string) (*JwsHeader, []byte, error) {
+	obj, err := ParseJws(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj.Verify(key, algs)
+}
+
+func algAllowed(alg string, algs []string) bool {
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return false
+	}
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// jwsVerificationKey turns the key accepted by Verify into a public key.
+func jwsVerificationKey(key interface{}) (crypto.PublicKey, error) {
+	switch key := key.(type) {
+	case json.RawMessage:
+		return jwkDecode(key)
+	case JWK:
+		return key.Public().Key, nil
+	case *JWK:
+		return key.Public().Key, nil
+	case []byte, *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	case crypto.Signer:
+		return key.Public(), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// jwsVerifySignature checks sig over the JWS signing input.
+func jwsVerifySignature(pub crypto.PublicKey, alg string, input string, sig []byte) error {
+	d, err := newJwsVerifyDigest(pub, alg)
+	if err != nil {
+		return err
+	}
+	io.WriteString(d, input)
+	return d.verify(pub, sig)
+}
+
+// newJwsVerifyDigest checks pub against alg and starts the digest to verify with.
+func newJwsVerifyDigest(pub crypto.PublicKey, alg string) (*jwsDigest, error) {
+	if err := CheckKeyAlgorithm(pub, alg); err != nil {
+		return nil, err
+	}
+	secret, _ := pub.([]byte)
+	return newJwsDigest(alg, secret)
+}
+
+// verify checks sig over the collected signing input.
+func (d *jwsDigest) verify(pub crypto.PublicKey, sig []byte) error {
+	if d.mac {
+		if !hmac.Equal(d.h.Sum(nil), sig) {
+			return ErrJwsSignature
+		}
+		return nil
+	}
+	if d.alg == AlgEdDSA {
+		if !ed25519.Verify(pub.(ed25519.PublicKey), d.msg.Bytes(), sig) {
+			return ErrJwsSignature
+		}
+		return nil
+	}
+	digest := d.h.Sum(nil)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch d.alg {
+		case AlgPS256, AlgPS384, AlgPS512:
+			err = rsa.VerifyPSS(pub, d.hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: d.hash})
+		default:
+			err = rsa.VerifyPKCS1v15(pub, d.hash, digest, sig)
+		}
+		if err != nil {
+			return ErrJwsSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrJwsSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrJwsSignature
+		}
+		return nil
+	}
+	return ErrUnsupportedKey
+}
@@ -0,0 +1,148 @@
+package encrypt
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// https://tools.ietf.org/html/rfc7515#appendix-A.1
+const (
+	rfc7515HS256Key = "AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow" // "k" of the oct JWK
+	rfc7515HS256    = "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfc7515Payload = "{\"iss\":\"joe\",\r\n \"exp\":1300819380,\r\n \"http://example.com/is_root\":true}"
+)
+
+// https://tools.ietf.org/html/rfc7515#appendix-A.3
+const (
+	rfc7515ES256Key = `{"kty":"EC","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0","d":"jpsQnnGQmL-YBIffH1136cspYG6-0iY7X1fCE9-E9LI"}`
+	rfc7515ES256    = "eyJhbGciOiJFUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".DtEhU3ljbEg8L38VWAfUAqOyKAM6-Xx-F4GawxaepmXFCgfTjDxw5djxLa8ISlSApmWQxfKTUJqPP3-Kg6NU1Q"
+)
+
+func b64Decode(t *testing.T, s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func parseTestJWK(t *testing.T, s string) JWK {
+	var jwk JWK
+	if err := json.Unmarshal([]byte(s), &jwk); err != nil {
+		t.Fatal(err)
+	}
+	return jwk
+}
+
+func TestJwsVerifyRFC7515(t *testing.T) {
+	for _, tc := range []struct {
+		name, jws, alg string
+		key            interface{}
+	}{
+		{"A.1 HS256", rfc7515HS256, AlgHS256, b64Decode(t, rfc7515HS256Key)},
+		{"A.3 ES256", rfc7515ES256, AlgES256, parseTestJWK(t, rfc7515ES256Key).Key},
+	} {
+		head, payload, err := JwsVerify([]byte(tc.jws), tc.key, []string{tc.alg})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if head.Alg != tc.alg || string(payload) != rfc7515Payload {
+			t.Fatalf("%s: %+v %q", tc.name, head, payload)
+		}
+	}
+	// a public JWK, also as raw JSON
+	es := parseTestJWK(t, rfc7515ES256Key)
+	for _, key := range []interface{}{es.Public(), json.RawMessage(rfc7515ES256Key)} {
+		if _, _, err := JwsVerify([]byte(rfc7515ES256), key, []string{AlgES256}); err != nil {
+			t.Fatalf("%T: %v", key, err)
+		}
+	}
+}
+
+func TestJwsHmacSignRFC7515(t *testing.T) {
+	secret := b64Decode(t, rfc7515HS256Key)
+	parts := strings.Split(rfc7515HS256, ".")
+	sig, err := jwsHmacSign(secret, AlgHS256, parts[0]+"."+parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b64(sig); got != parts[2] {
+		t.Fatalf("HS256 signature %s, want %s", got, parts[2])
+	}
+}
+
+func TestJwsVerifyRejects(t *testing.T) {
+	secret := b64Decode(t, rfc7515HS256Key)
+	es := parseTestJWK(t, rfc7515ES256Key)
+	parts := strings.Split(rfc7515HS256, ".")
+	flip := func(s string) string {
+		b := []byte(s)
+		if b[len(b)/2] == 'A' {
+			b[len(b)/2] = 'B'
+		} else {
+			b[len(b)/2] = 'A'
+		}
+		return string(b)
+	}
+	for _, tc := range []struct {
+		name string
+		jws  string
+		key  interface{}
+		algs []string
+		want error
+	}{
+		{"tampered payload", parts[0] + "." + flip(parts[1]) + "." + parts[2], secret, []string{AlgHS256}, ErrJwsSignature},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + flip(parts[2]), secret, []string{AlgHS256}, ErrJwsSignature},
+		{"truncated signature", parts[0] + "." + parts[1] + "." + parts[2][:20], secret, []string{AlgHS256}, ErrJwsSignature},
+		{"missing part", parts[0] + "." + parts[1], secret, []string{AlgHS256}, ErrJwsMalformed},
+		{"alg not allowed", rfc7515HS256, secret, []string{AlgHS512}, ErrUnsupportedAlgorithm},
+		{"empty allowlist", rfc7515HS256, secret, nil, ErrUnsupportedAlgorithm},
+		{"wrong key type", rfc7515HS256, es.Public().Key, []string{AlgHS256}, ErrKeyAlgorithmMismatch},
+		{"short secret", rfc7515HS256, secret[:16], []string{AlgHS256}, ErrKeyAlgorithmMismatch},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + ".", secret, []string{"none"}, ErrUnsupportedAlgorithm},
+		{"unknown crit", b64([]byte(`{"alg":"HS256","crit":["exp"],"exp":1}`)) + "." + parts[1] + "." + parts[2], secret, []string{AlgHS256}, ErrJwsMalformed},
+		{"null header", b64([]byte(`null`)) + "." + parts[1] + "." + parts[2], secret, []string{AlgHS256}, ErrUnsupportedAlgorithm},
+	} {
+		_, payload, err := JwsVerify([]byte(tc.jws), tc.key, tc.algs)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+		if payload != nil {
+			t.Errorf("%s: payload returned with an error", tc.name)
+		}
+	}
+}
+
+func TestJwsVerifyGeneralJSON(t *testing.T) {
+	secret := b64Decode(t, rfc7515HS256Key)
+	es := parseTestJWK(t, rfc7515ES256Key)
+	flattened, err := JwsSign([]byte("hello"), es.Key.(crypto.Signer), AlgES256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ecSig jwsJSONSignature
+	json.Unmarshal(flattened, &ecSig)
+	hsHead := b64([]byte(`{"alg":"HS256"}`))
+	mac, err := jwsHmacSign(secret, AlgHS256, hsHead+"."+b64([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	general, _ := json.Marshal(map[string]interface{}{
+		"payload":    b64([]byte("hello")),
+		"signatures": []jwsJSONSignature{{Protected: hsHead, Signature: b64(mac)}, ecSig},
+	})
+	// either signature is enough, with the key that fits it
+	for _, key := range []interface{}{secret, es.Public()} {
+		if _, payload, err := JwsVerify(general, key, []string{AlgHS256, AlgES256}); err != nil || string(payload) != "hello" {
+			t.Fatalf("%T: %v", key, err)
+		}
+	}
+}
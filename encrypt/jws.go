@@ -2,22 +2,56 @@
 package encrypt
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"math/big"
 )
 
 // ErrUnsupportedKey is returned when an unsupported key type is encountered.
-var ErrUnsupportedKey = errors.New("acme: unknown key type; only RSA and ECDSA are supported")
+var ErrUnsupportedKey = errors.New("acme: unknown key type; only RSA, ECDSA and Ed25519 are supported")
 
-// jwkEncode encodes public part of an RSA or ECDSA key into a JWK.
+var (
+	// ErrUnsupportedAlgorithm is returned for an unknown or disallowed JWS "alg".
+	ErrUnsupportedAlgorithm = errors.New("jws: unsupported algorithm")
+	// ErrKeyAlgorithmMismatch is returned when a key cannot be used with the chosen "alg".
+	ErrKeyAlgorithmMismatch = errors.New("jws: key type or size does not fit algorithm")
+)
+
+// JWS signature algorithms, see https://tools.ietf.org/html/rfc7518#section-3.1
+const (
+	AlgHS256 = "HS256"
+	AlgHS384 = "HS384"
+	AlgHS512 = "HS512"
+	AlgRS256 = "RS256"
+	AlgRS384 = "RS384"
+	AlgRS512 = "RS512"
+	AlgPS256 = "PS256"
+	AlgPS384 = "PS384"
+	AlgPS512 = "PS512"
+	AlgES256 = "ES256"
+	AlgES384 = "ES384"
+	AlgES512 = "ES512"
+	AlgEdDSA = "EdDSA" // https://tools.ietf.org/html/rfc8037#section-3.1
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for RS* and PS*.
+// https://tools.ietf.org/html/rfc7518#section-3.3
+const minRSAKeyBits = 2048
+
+// jwkEncode encodes public part of an RSA, ECDSA or Ed25519 key into a JWK.
 // The result is also suitable for creating a JWK thumbprint.
 // https://tools.ietf.org/html/rfc7517
 func jwkEncode(pub crypto.PublicKey) (string, error) {
@@ -54,80 +88,236 @@ func jwkEncode(pub crypto.PublicKey) (string, error) {
 			base64.RawURLEncoding.EncodeToString(x),
 			base64.RawURLEncoding.EncodeToString(y),
 		), nil
+	case ed25519.PublicKey:
+		// https://tools.ietf.org/html/rfc8037#section-2
+		// Field order is important.
+		// See https://tools.ietf.org/html/rfc8037#appendix-A.3 for details.
+		return fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(pub),
+		), nil
 	}
 	return "", ErrUnsupportedKey
 }
 
-// FIXME: support any legel length of crypto?
+// GetKeyAlgorithm returns the default JWS algorithm for pub.
+// RSA keys default to RS256, use JwsSign to pick another algorithm.
 func GetKeyAlgorithm(pub crypto.PublicKey) (string, error) {
-	switch pub.(type) {
+	switch pub := pub.(type) {
 	case *rsa.PublicKey:
-		alg := "RS256"
+		alg := AlgRS256
 		return alg, nil
 	case *ecdsa.PublicKey:
-		alg := "HS256"
-		return alg, nil
+		// https://tools.ietf.org/html/rfc7518#section-3.4
+		switch pub.Curve.Params().Name {
+		case "P-256":
+			return AlgES256, nil
+		case "P-384":
+			return AlgES384, nil
+		case "P-521":
+			return AlgES512, nil
+		}
+		return "", ErrUnsupportedKey
+	case ed25519.PublicKey:
+		return AlgEdDSA, nil
 	default:
 		return "", ErrUnsupportedKey
 	}
 }
 
-// JWKThumbprint creates a JWK thumbprint out of pub
-// as specified in https://tools.ietf.org/html/rfc7638.
-func JWKThumbprint(pub crypto.PublicKey) (string, error) {
-	jwk, err := jwkEncode(pub)
+// CheckKeyAlgorithm reports whether pub can be used with alg.
+// HS* algorithms take the shared secret as []byte.
+func CheckKeyAlgorithm(pub crypto.PublicKey, alg string) error {
+	hash, err := jwsHasher(alg)
 	if err != nil {
-		return "", err
+		return err
 	}
-	b := sha256.Sum256([]byte(jwk))
-	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+	switch pub := pub.(type) {
+	case []byte:
+		switch alg {
+		case AlgHS256, AlgHS384, AlgHS512:
+			// https://tools.ietf.org/html/rfc7518#section-3.2
+			if len(pub) < hash.Size() {
+				return fmt.Errorf("%w: HMAC secret must be at least %d bytes", ErrKeyAlgorithmMismatch, hash.Size())
+			}
+			return nil
+		}
+	case *rsa.PublicKey:
+		switch alg {
+		case AlgRS256, AlgRS384, AlgRS512, AlgPS256, AlgPS384, AlgPS512:
+			if pub.N.BitLen() < minRSAKeyBits {
+				return fmt.Errorf("%w: RSA key must be at least %d bits", ErrKeyAlgorithmMismatch, minRSAKeyBits)
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		want, err := GetKeyAlgorithm(pub)
+		if err != nil {
+			return err
+		}
+		if want == alg {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if alg == AlgEdDSA {
+			return nil
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return fmt.Errorf("%w: %T cannot sign %s", ErrKeyAlgorithmMismatch, pub, alg)
 }
 
-// JwsEncodeJson helpers
-func jwsEcdsaEncodeJSON(claimset interface{}, key *ecdsa.PrivateKey, nonce string, url string) ([]byte, error) {
-	jwk, err := jwkEncode(key.Public())
+// jwsHasher returns the hash function used by alg.
+// EdDSA signs the message itself, so it has no hash.
+func jwsHasher(alg string) (crypto.Hash, error) {
+	switch alg {
+	case AlgEdDSA:
+		return 0, nil
+	case AlgHS256, AlgRS256, AlgPS256, AlgES256:
+		return crypto.SHA256, nil
+	case AlgHS384, AlgRS384, AlgPS384, AlgES384:
+		return crypto.SHA384, nil
+	case AlgHS512, AlgRS512, AlgPS512, AlgES512:
+		return crypto.SHA512, nil
+	}
+	return 0, ErrUnsupportedAlgorithm
+}
+
+// jwsSignerOpts returns the options passed to crypto.Signer for alg.
+func jwsSignerOpts(alg string, hash crypto.Hash) crypto.SignerOpts {
+	switch alg {
+	case AlgPS256, AlgPS384, AlgPS512:
+		// https://tools.ietf.org/html/rfc7518#section-3.5
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	return hash
+}
+
+// jwsDigest collects the JWS signing input for alg. Hash based algorithms
+// stream it straight into the hash (or HMAC), EdDSA signs the message itself
+// so it has to be kept in memory.
+type jwsDigest struct {
+	alg  string
+	hash crypto.Hash
+	h    hash.Hash
+	mac  bool
+	msg  bytes.Buffer
+}
+
+// newJwsDigest starts a digest for alg, keyed with secret for HS* algorithms.
+func newJwsDigest(alg string, secret []byte) (*jwsDigest, error) {
+	hash, err := jwsHasher(alg)
 	if err != nil {
 		return nil, err
 	}
-	phead := fmt.Sprintf(`{"alg":"HS256","jwk":%s,"nonce":%q,"url":%q}`, jwk, nonce, url)
-	phead = base64.RawURLEncoding.EncodeToString([]byte(phead))
-	cs, err := json.Marshal(claimset)
+	d := &jwsDigest{alg: alg, hash: hash}
+	switch alg {
+	case AlgEdDSA:
+	case AlgHS256, AlgHS384, AlgHS512:
+		if secret == nil {
+			return nil, fmt.Errorf("%w: %s needs a secret", ErrKeyAlgorithmMismatch, alg)
+		}
+		d.h, d.mac = hmac.New(hash.New, secret), true
+	default:
+		d.h = hash.New()
+	}
+	return d, nil
+}
+
+func (d *jwsDigest) Write(p []byte) (int, error) {
+	if d.h == nil {
+		return d.msg.Write(p)
+	}
+	return d.h.Write(p)
+}
+
+// sign signs the collected input with key, HS* digests return the MAC and ignore key.
+// crypto.Signer returns ECDSA signatures ASN.1 DER encoded, while JWS wants
+// the fixed width r||s pair, so those are converted here.
+func (d *jwsDigest) sign(key crypto.Signer) ([]byte, error) {
+	if d.mac {
+		return d.h.Sum(nil), nil
+	}
+	if d.alg == AlgEdDSA {
+		return key.Sign(rand.Reader, d.msg.Bytes(), crypto.Hash(0))
+	}
+	sig, err := key.Sign(rand.Reader, d.h.Sum(nil), jwsSignerOpts(d.alg, d.hash))
 	if err != nil {
 		return nil, err
 	}
-	payload := base64.RawURLEncoding.EncodeToString(cs)
-	h := sha256.New()
-	h.Write([]byte(phead + "." + payload))
-	sig, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	pub, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+		return nil, err
+	}
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	// a misbehaving Signer must not make FillBytes panic
+	if rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.BitLen() > 8*size || rs.S.BitLen() > 8*size {
+		return nil, errors.New("jws: ECDSA signature does not fit the curve size")
+	}
+	out := make([]byte, 2*size)
+	rs.R.FillBytes(out[:size])
+	rs.S.FillBytes(out[size:])
+	return out, nil
+}
+
+// jwsSign signs the JWS signing input with key.
+func jwsSign(key crypto.Signer, alg string, input string) ([]byte, error) {
+	d, err := newJwsDigest(alg, nil)
 	if err != nil {
 		return nil, err
 	}
-	enc := struct {
-		Protected string `json:"protected"`
-		Payload   string `json:"payload"`
-		Sig       string `json:"signature"`
-	}{
-		Protected: phead,
-		Payload:   payload,
-		Sig:       base64.RawURLEncoding.EncodeToString(sig),
+	io.WriteString(d, input)
+	return d.sign(key)
+}
+
+// jwsHmacSign computes an HS256/384/512 signature over the JWS signing input.
+func jwsHmacSign(secret []byte, alg string, input string) ([]byte, error) {
+	if err := CheckKeyAlgorithm(secret, alg); err != nil {
+		return nil, err
 	}
-	return json.Marshal(&enc)
+	d, err := newJwsDigest(alg, secret)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(d, input)
+	return d.sign(nil)
+}
+
+// JWKThumbprint creates a JWK thumbprint out of pub
+// as specified in https://tools.ietf.org/html/rfc7638.
+func JWKThumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := jwkEncode(pub)
+	if err != nil {
+		return "", err
+	}
+	b := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
 }
-func jwsRsaEncodeJSON(claimset interface{}, key *rsa.PrivateKey, nonce string, url string) ([]byte, error) {
+
+// JwsEncodeJson helper
+func jwsEncodeJSON(claimset interface{}, key crypto.Signer, nonce string, url string) ([]byte, error) {
+	alg, err := GetKeyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
 	jwk, err := jwkEncode(key.Public())
 	if err != nil {
 		return nil, err
 	}
-	phead := fmt.Sprintf(`{"alg":"RS256","jwk":%s,"nonce":%q,"url":%q}`, jwk, nonce, url)
+	phead := fmt.Sprintf(`{"alg":%q,"jwk":%s,"nonce":%q,"url":%q}`, alg, jwk, nonce, url)
 	phead = base64.RawURLEncoding.EncodeToString([]byte(phead))
 	cs, err := json.Marshal(claimset)
 	if err != nil {
 		return nil, err
 	}
 	payload := base64.RawURLEncoding.EncodeToString(cs)
-	h := sha256.New()
-	h.Write([]byte(phead + "." + payload))
-	sig, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	sig, err := jwsSign(key, alg, phead+"."+payload)
 	if err != nil {
 		return nil, err
 	}
@@ -146,17 +336,14 @@ func jwsRsaEncodeJSON(claimset interface{}, key *rsa.PrivateKey, nonce string, u
 // jwsEncodeJSON signs claimset using provided key and a nonce.
 // The result is serialized in JSON format.
 // See https://tools.ietf.org/html/rfc7515#section-7.
+// privKey may be any crypto.Signer, such as a key held by a remote signing
+// service, as long as its public key is RSA, ECDSA or Ed25519.
 func JwsEncodeJSON(claimset interface{}, privKey crypto.PrivateKey, nonce string, url string) ([]byte, error) {
-	switch key := privKey.(type) {
-	case *rsa.PrivateKey:
-		data, err := jwsRsaEncodeJSON(claimset, key, nonce, url)
-		return data, err
-	case *ecdsa.PrivateKey:
-		data, err := jwsEcdsaEncodeJSON(claimset, key, nonce, url)
-		return data, err
-	default:
+	key, ok := privKey.(crypto.Signer)
+	if !ok {
 		return nil, ErrUnsupportedKey
 	}
+	return jwsEncodeJSON(claimset, key, nonce, url)
 }
 
 func JwsEncodeJSONWithKid(claimset interface{}, key crypto.Signer, nonce string, url string, kid string) ([]byte, error) {
@@ -177,9 +364,7 @@ func JwsEncodeJSONWithKid(claimset interface{}, key crypto.Signer, nonce string,
 		}
 		payload = base64.RawURLEncoding.EncodeToString(cs)
 	}
-	h := sha256.New()
-	h.Write([]byte(phead + "." + payload))
-	sig, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	sig, err := jwsSign(key, alg, phead+"."+payload)
 	if err != nil {
 		return nil, err
 	}
@@ -203,9 +388,7 @@ func JwsEncodeStringWithKid(payload string, key crypto.Signer, nonce string, url
 	phead := fmt.Sprintf(`{"alg":%q,"kid":%q,"nonce":%q,"url":%q}`, alg, kid, nonce, url)
 	phead = base64.RawURLEncoding.EncodeToString([]byte(phead))
 	payload = base64.RawURLEncoding.EncodeToString([]byte(payload))
-	h := sha256.New()
-	h.Write([]byte(phead + "." + payload))
-	sig, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	sig, err := jwsSign(key, alg, phead+"."+payload)
 	if err != nil {
 		return nil, err
 	}
@@ -220,3 +403,35 @@ func JwsEncodeStringWithKid(payload string, key crypto.Signer, nonce string, url
 	}
 	return json.Marshal(&enc)
 }
+
+// JwsSign signs payload with an explicitly chosen alg and returns the
+// flattened JSON serialization. header holds additional protected header
+// members such as "kid" or "typ", "alg" is always taken from alg.
+func JwsSign(payload []byte, key crypto.Signer, alg string, header map[string]interface{}) ([]byte, error) {
+	if err := CheckKeyAlgorithm(key.Public(), alg); err != nil {
+		return nil, err
+	}
+	protected := make(map[string]interface{}, len(header)+1)
+	for k, v := range header {
+		protected[k] = v
+	}
+	protected["alg"] = alg
+	phead, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	enc := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Sig       string `json:"signature"`
+	}{
+		Protected: base64.RawURLEncoding.EncodeToString(phead),
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+	}
+	sig, err := jwsSign(key, alg, enc.Protected+"."+enc.Payload)
+	if err != nil {
+		return nil, err
+	}
+	enc.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	return json.Marshal(&enc)
+}
@@ -0,0 +1,165 @@
+package encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+)
+
+func TestJwsSignECDSA(t *testing.T) {
+	for _, tc := range []struct {
+		curve elliptic.Curve
+		alg   string
+		size  int
+	}{
+		{elliptic.P256(), AlgES256, 64},
+		{elliptic.P384(), AlgES384, 96},
+		{elliptic.P521(), AlgES512, 132},
+	} {
+		key, err := ecdsa.GenerateKey(tc.curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alg, _ := GetKeyAlgorithm(key.Public()); alg != tc.alg {
+			t.Fatalf("%s: default algorithm %s", tc.alg, alg)
+		}
+		data, err := JwsSign([]byte("payload"), key, tc.alg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var enc struct{ Signature string }
+		json.Unmarshal(data, &enc)
+		sig, _ := base64.RawURLEncoding.DecodeString(enc.Signature)
+		if len(sig) != tc.size {
+			t.Fatalf("%s: signature is %d bytes, want raw r||s of %d", tc.alg, len(sig), tc.size)
+		}
+		if _, payload, err := JwsVerify(data, key.Public(), []string{tc.alg}); err != nil || string(payload) != "payload" {
+			t.Fatalf("%s: %v", tc.alg, err)
+		}
+	}
+}
+
+// oversizedSigner returns a DER signature whose r does not fit the curve.
+type oversizedSigner struct {
+	*ecdsa.PrivateKey
+}
+
+func (s oversizedSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	r := new(big.Int).Lsh(big.NewInt(1), 300)
+	return asn1.Marshal(struct{ R, S *big.Int }{r, big.NewInt(1)})
+}
+
+func TestJwsSignECDSAOversized(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := JwsSign([]byte("payload"), oversizedSigner{key}, AlgES256, nil); err == nil {
+		t.Fatal("oversized r accepted")
+	}
+}
+
+func TestJwsSignRSAAlgorithms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		alg  string
+		hash crypto.Hash
+		pss  bool
+	}{
+		{AlgRS256, crypto.SHA256, false},
+		{AlgRS384, crypto.SHA384, false},
+		{AlgRS512, crypto.SHA512, false},
+		{AlgPS256, crypto.SHA256, true},
+		{AlgPS384, crypto.SHA384, true},
+		{AlgPS512, crypto.SHA512, true},
+	} {
+		data, err := JwsSign([]byte("payload"), key, tc.alg, map[string]interface{}{"kid": "k1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var enc struct{ Protected, Payload, Signature string }
+		json.Unmarshal(data, &enc)
+		sig, _ := base64.RawURLEncoding.DecodeString(enc.Signature)
+		h := tc.hash.New()
+		h.Write([]byte(enc.Protected + "." + enc.Payload))
+		// checked with crypto/rsa directly, PSS salt is the hash size
+		if tc.pss {
+			err = rsa.VerifyPSS(&key.PublicKey, tc.hash, h.Sum(nil), sig, &rsa.PSSOptions{SaltLength: tc.hash.Size()})
+		} else {
+			err = rsa.VerifyPKCS1v15(&key.PublicKey, tc.hash, h.Sum(nil), sig)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.alg, err)
+		}
+		head, _, err := JwsVerify(data, &key.PublicKey, []string{tc.alg})
+		if err != nil || head.Alg != tc.alg || head.Kid != "k1" {
+			t.Fatalf("%s: %v %+v", tc.alg, err, head)
+		}
+		// the alg in the header must be allowed
+		if _, _, err := JwsVerify(data, &key.PublicKey, []string{AlgRS256}); tc.alg != AlgRS256 && !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Fatalf("%s verified with only RS256 allowed: %v", tc.alg, err)
+		}
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := JwsSign([]byte("payload"), small, AlgPS256, nil); !errors.Is(err, ErrKeyAlgorithmMismatch) {
+		t.Fatalf("1024 bit key: %v", err)
+	}
+	if _, err := JwsSign([]byte("payload"), key, AlgES256, nil); !errors.Is(err, ErrKeyAlgorithmMismatch) {
+		t.Fatalf("RSA key with ES256: %v", err)
+	}
+}
+
+// https://tools.ietf.org/html/rfc8037#appendix-A
+const (
+	rfc8037Key        = `{"kty":"OKP","crv":"Ed25519","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`
+	rfc8037Thumbprint = "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"
+	rfc8037JWS        = "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc" +
+		".hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+)
+
+func TestJwsEdDSARFC8037(t *testing.T) {
+	jwk := parseTestJWK(t, rfc8037Key)
+	key, ok := jwk.Key.(ed25519.PrivateKey)
+	if !ok {
+		t.Fatalf("OKP JWK parsed as %T", jwk.Key)
+	}
+	if tp, err := JWKThumbprint(key.Public()); err != nil || tp != rfc8037Thumbprint {
+		t.Fatalf("thumbprint %s %v", tp, err)
+	}
+	if alg, _ := GetKeyAlgorithm(key.Public()); alg != AlgEdDSA {
+		t.Fatalf("default algorithm %s", alg)
+	}
+	data, err := JwsSign([]byte("Example of Ed25519 signing"), key, AlgEdDSA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enc struct{ Protected, Payload, Signature string }
+	json.Unmarshal(data, &enc)
+	// Ed25519 is deterministic, the signature matches the RFC byte for byte
+	if got := enc.Protected + "." + enc.Payload + "." + enc.Signature; got != rfc8037JWS {
+		t.Fatalf("got %s\nwant %s", got, rfc8037JWS)
+	}
+	if _, payload, err := JwsVerify([]byte(rfc8037JWS), jwk.Public(), []string{AlgEdDSA}); err != nil || string(payload) != "Example of Ed25519 signing" {
+		t.Fatalf("verify: %v", err)
+	}
+	if _, _, err := JwsVerify([]byte(rfc8037JWS[:len(rfc8037JWS)-2]+"AA"), jwk.Public(), []string{AlgEdDSA}); !errors.Is(err, ErrJwsSignature) {
+		t.Fatalf("tampered signature: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package encrypt
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JWT on top of the JWS helpers, see https://tools.ietf.org/html/rfc7519
+
+var (
+	ErrJwtMalformed    = errors.New("jwt: malformed token")
+	ErrJwtExpired      = errors.New("jwt: token is expired")
+	ErrJwtNotYetValid  = errors.New("jwt: token is not valid yet")
+	ErrJwtIssuer       = errors.New("jwt: unexpected issuer")
+	ErrJwtAudience     = errors.New("jwt: unexpected audience")
+	ErrJwtMissingClaim = errors.New("jwt: required claim is missing")
+)
+
+// JwtAudience is the "aud" claim, which may be a single string or an array.
+type JwtAudience []string
+
+func (a JwtAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *JwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = JwtAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a JwtAudience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// JwtClaims holds the registered claims, anything else goes to Custom.
+// Zero times are left out of the token.
+type JwtClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  JwtAudience
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Custom    map[string]interface{}
+}
+
+type jwtRegisteredClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JwtAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+var jwtRegisteredNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func (c JwtClaims) MarshalJSON() ([]byte, error) {
+	registered, err := json.Marshal(jwtRegisteredClaims{
+		Issuer:    c.Issuer,
+		Subject:   c.Subject,
+		Audience:  c.Audience,
+		ExpiresAt: unixOrZero(c.ExpiresAt),
+		NotBefore: unixOrZero(c.NotBefore),
+		IssuedAt:  unixOrZero(c.IssuedAt),
+		ID:        c.ID,
+	})
+	if err != nil || len(c.Custom) == 0 {
+		return registered, err
+	}
+	all := make(map[string]interface{}, len(c.Custom)+len(jwtRegisteredNames))
+	for k, v := range c.Custom {
+		all[k] = v
+	}
+	// registered claims always win over custom ones with the same name
+	if err := json.Unmarshal(registered, &all); err != nil {
+		return nil, err
+	}
+	return json.Marshal(all)
+}
+
+func (c *JwtClaims) UnmarshalJSON(data []byte) error {
+	var registered jwtRegisteredClaims
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, name := range jwtRegisteredNames {
+		delete(all, name)
+	}
+	*c = JwtClaims{
+		Issuer:    registered.Issuer,
+		Subject:   registered.Subject,
+		Audience:  registered.Audience,
+		ExpiresAt: timeOrZero(registered.ExpiresAt),
+		NotBefore: timeOrZero(registered.NotBefore),
+		IssuedAt:  timeOrZero(registered.IssuedAt),
+		ID:        registered.ID,
+	}
+	if len(all) > 0 {
+		c.Custom = all
+	}
+	return nil
+}
+
+// JwtSign returns the compact serialization of claims.
+// key is the []byte (or string) secret for HS256/384/512,
+// or a crypto.Signer for the RSA and ECDSA algorithms.
+func JwtSign(claims JwtClaims, key interface{}, alg string, kid string) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	phead, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(phead) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch key := key.(type) {
+	case string:
+		sig, err = jwsHmacSign([]byte(key), alg, input)
+	case []byte:
+		sig, err = jwsHmacSign(key, alg, input)
+	case crypto.Signer:
+		if err := CheckKeyAlgorithm(key.Public(), alg); err != nil {
+			return "", err
+		}
+		sig, err = jwsSign(key, alg, input)
+	default:
+		return "", ErrUnsupportedKey
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// JwtValidateOption controls JwtParse. Algorithms is required, and so are
+// Issuer and Audience unless SkipIssuer / SkipAudience opt out of the check.
+type JwtValidateOption struct {
+	Algorithms    []string
+	Issuer        string
+	Audience      string
+	SkipIssuer    bool             // accept any "iss"
+	SkipAudience  bool             // accept any "aud"
+	RequireExpiry bool             // reject tokens without "exp"
+	Leeway        time.Duration    // allowed clock skew for exp and nbf
+	Now           func() time.Time // defaults to time.Now
+}
+
+// JwtParse verifies token with key (see JwsObject.Verify, a string is taken
+// as an HMAC secret) and checks the registered claims.
+// Expired and not yet valid tokens return ErrJwtExpired / ErrJwtNotYetValid.
+// Claims are only returned for a token that passed every check.
+func JwtParse(token string, key interface{}, option JwtValidateOption) (*JwtClaims, error) {
+	if option.Issuer == "" && !option.SkipIssuer {
+		return nil, errors.New("jwt: no issuer to check, set SkipIssuer to accept any")
+	}
+	if option.Audience == "" && !option.SkipAudience {
+		return nil, errors.New("jwt: no audience to check, set SkipAudience to accept any")
+	}
+	if strings.HasPrefix(strings.TrimSpace(token), "{") {
+		return nil, ErrJwtMalformed
+	}
+	if secret, ok := key.(string); ok {
+		key = []byte(secret)
+	}
+	head, payload, err := JwsVerify([]byte(token), key, option.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+	if head.Typ != "" && !strings.EqualFold(head.Typ, "JWT") {
+		return nil, fmt.Errorf("%w: typ %q", ErrJwtMalformed, head.Typ)
+	}
+	claims := &JwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJwtMalformed, err)
+	}
+
+	now := time.Now()
+	if option.Now != nil {
+		now = option.Now()
+	}
+	if claims.ExpiresAt.IsZero() && option.RequireExpiry {
+		return nil, fmt.Errorf("%w: no exp claim", ErrJwtMissingClaim)
+	}
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(option.Leeway)) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrJwtExpired, claims.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if !claims.NotBefore.IsZero() && now.Add(option.Leeway).Before(claims.NotBefore) {
+		return nil, fmt.Errorf("%w: valid from %s", ErrJwtNotYetValid, claims.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if !option.SkipIssuer && claims.Issuer != option.Issuer {
+		return nil, fmt.Errorf("%w: %q", ErrJwtIssuer, claims.Issuer)
+	}
+	if !option.SkipAudience && !claims.Audience.contains(option.Audience) {
+		return nil, fmt.Errorf("%w: %q not in %v", ErrJwtAudience, option.Audience, []string(claims.Audience))
+	}
+	return claims, nil
+}
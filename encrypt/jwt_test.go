@@ -0,0 +1,163 @@
+package encrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The RFC 7515 A.1 token is the example JWT of RFC 7519 section 3.1.
+func TestJwtParseRFC7519(t *testing.T) {
+	secret := b64Decode(t, rfc7515HS256Key)
+	exp := time.Unix(1300819380, 0)
+	option := JwtValidateOption{
+		Algorithms:   []string{AlgHS256},
+		Issuer:       "joe",
+		SkipAudience: true,
+		Now:          func() time.Time { return exp.Add(-time.Minute) },
+	}
+	claims, err := JwtParse(rfc7515HS256, secret, option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "joe" || !claims.ExpiresAt.Equal(exp) || claims.Custom["http://example.com/is_root"] != true {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	option.Now = func() time.Time { return exp }
+	if claims, err := JwtParse(rfc7515HS256, secret, option); !errors.Is(err, ErrJwtExpired) || claims != nil {
+		t.Fatalf("at exp: %v %v", claims, err)
+	}
+	option.Leeway = time.Minute
+	if _, err := JwtParse(rfc7515HS256, secret, option); err != nil {
+		t.Fatalf("within leeway: %v", err)
+	}
+}
+
+func TestJwtSignParse(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	claims := JwtClaims{
+		Issuer:    "https://issuer.internal",
+		Subject:   "svc-a",
+		Audience:  JwtAudience{"api", "admin"},
+		ExpiresAt: now.Add(time.Hour),
+		NotBefore: now,
+		IssuedAt:  now,
+		ID:        "id-1",
+		Custom:    map[string]interface{}{"scope": "read", "iss": "ignored"},
+	}
+	option := JwtValidateOption{Issuer: claims.Issuer, Audience: "admin", RequireExpiry: true, Now: func() time.Time { return now }}
+	for _, tc := range []struct {
+		alg         string
+		sign, check interface{}
+	}{
+		{AlgHS256, "0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef"},
+		{AlgHS512, []byte(strings.Repeat("k", 64)), []byte(strings.Repeat("k", 64))},
+		{AlgES256, ec, &ec.PublicKey},
+	} {
+		token, err := JwtSign(claims, tc.sign, tc.alg, "kid-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		option.Algorithms = []string{tc.alg}
+		got, err := JwtParse(token, tc.check, option)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.alg, err)
+		}
+		if got.Issuer != claims.Issuer || got.Subject != "svc-a" || len(got.Audience) != 2 || got.ID != "id-1" ||
+			!got.ExpiresAt.Equal(claims.ExpiresAt) || got.Custom["scope"] != "read" || got.Custom["iss"] != nil {
+			t.Fatalf("%s: claims %+v", tc.alg, got)
+		}
+	}
+}
+
+func TestJwtParseRejects(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1700000000, 0)
+	sign := func(c JwtClaims) string {
+		token, err := JwtSign(c, secret, AlgHS256, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := JwtClaims{Issuer: "iss", Audience: JwtAudience{"aud"}, ExpiresAt: now.Add(time.Hour)}
+	option := JwtValidateOption{Algorithms: []string{AlgHS256}, Issuer: "iss", Audience: "aud", Now: func() time.Time { return now }}
+	forged, err := JwtSign(valid, []byte(strings.Repeat("x", 32)), AlgHS256, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(f func(*JwtClaims)) string {
+		c := valid
+		f(&c)
+		return sign(c)
+	}
+	for _, tc := range []struct {
+		name   string
+		token  string
+		option func(*JwtValidateOption)
+		want   error
+	}{
+		{"expired", with(func(c *JwtClaims) { c.ExpiresAt = now.Add(-time.Second) }), nil, ErrJwtExpired},
+		{"not yet valid", with(func(c *JwtClaims) { c.NotBefore = now.Add(time.Minute) }), nil, ErrJwtNotYetValid},
+		{"wrong issuer", with(func(c *JwtClaims) { c.Issuer = "other" }), nil, ErrJwtIssuer},
+		{"missing issuer", with(func(c *JwtClaims) { c.Issuer = "" }), nil, ErrJwtIssuer},
+		{"wrong audience", with(func(c *JwtClaims) { c.Audience = JwtAudience{"other"} }), nil, ErrJwtAudience},
+		{"missing audience", with(func(c *JwtClaims) { c.Audience = nil }), nil, ErrJwtAudience},
+		{"missing exp", with(func(c *JwtClaims) { c.ExpiresAt = time.Time{} }), func(o *JwtValidateOption) { o.RequireExpiry = true }, ErrJwtMissingClaim},
+		{"wrong secret", forged, nil, ErrJwsSignature},
+		{"alg not allowed", sign(valid), func(o *JwtValidateOption) { o.Algorithms = []string{AlgHS384} }, ErrUnsupportedAlgorithm},
+		{"JSON serialization", `{"payload":""}`, nil, ErrJwtMalformed},
+	} {
+		option := option
+		if tc.option != nil {
+			tc.option(&option)
+		}
+		claims, err := JwtParse(tc.token, secret, option)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+		if claims != nil {
+			t.Errorf("%s: claims returned with an error", tc.name)
+		}
+	}
+
+	// issuer and audience have to be given or explicitly skipped
+	token := sign(valid)
+	if _, err := JwtParse(token, secret, JwtValidateOption{Algorithms: []string{AlgHS256}, Audience: "aud", Now: option.Now}); err == nil {
+		t.Fatal("parsed without an issuer to check")
+	}
+	if _, err := JwtParse(token, secret, JwtValidateOption{Algorithms: []string{AlgHS256}, Issuer: "iss", Now: option.Now}); err == nil {
+		t.Fatal("parsed without an audience to check")
+	}
+	if _, err := JwtParse(token, secret, JwtValidateOption{Algorithms: []string{AlgHS256}, SkipIssuer: true, SkipAudience: true, Now: option.Now}); err != nil {
+		t.Fatalf("skipped checks: %v", err)
+	}
+}
+
+func TestJwtAudienceJSON(t *testing.T) {
+	for _, tc := range []struct {
+		aud  JwtAudience
+		json string
+	}{
+		{JwtAudience{"a"}, `"a"`},
+		{JwtAudience{"a", "b"}, `["a","b"]`},
+	} {
+		data, err := tc.aud.MarshalJSON()
+		if err != nil || string(data) != tc.json {
+			t.Fatalf("%v: %s %v", tc.aud, data, err)
+		}
+		var back JwtAudience
+		if err := back.UnmarshalJSON(data); err != nil || len(back) != len(tc.aud) {
+			t.Fatalf("%s: %v %v", tc.json, back, err)
+		}
+	}
+}
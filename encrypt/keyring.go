@@ -0,0 +1,182 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var (
+	// ErrKeyNotFound is returned for an unknown purpose, key id or generation.
+	ErrKeyNotFound = errors.New("keyring: key not found")
+	// ErrKeyExpired is returned for a generation whose grace window has passed.
+	ErrKeyExpired = errors.New("keyring: key generation expired")
+)
+
+// Purposes for subkeys derived from the HMAC seed. Any other non empty
+// string works too, as long as every service agrees on it.
+const (
+	PurposeWebhook   = "webhook-signing"
+	PurposeCookie    = "cookie-sealing"
+	PurposeGrpcToken = "grpc-token"
+	PurposeSignedURL = "signed-url"
+)
+
+// derivedKeySize is the length of every derived key, enough for HMAC-SHA256 and AES-256.
+const derivedKeySize = 32
+
+// keyringSalt separates our derivations from anything else using the same seed.
+var keyringSalt = []byte("mygolibs derived keyring")
+
+// DerivedKey is a subkey for one purpose and seed generation.
+type DerivedKey struct {
+	ID      string // "<purpose>.v<version>", safe to send as a kid
+	Purpose string
+	Version int
+	Key     []byte
+}
+
+type keyGeneration struct {
+	version  int
+	seed     []byte
+	notAfter time.Time // zero for the current generation
+}
+
+// DerivedKeyring derives purpose scoped subkeys from the configured HMAC seed
+// (StellarConf.HMACSeed) with HKDF, https://tools.ietf.org/html/rfc5869
+// Every seed is a generation. After Rotate, signing moves to the new one
+// while older generations keep verifying until their grace window ends.
+type DerivedKeyring struct {
+	Now func() time.Time
+
+	mu          sync.RWMutex
+	generations []keyGeneration // newest first
+}
+
+// NewDerivedKeyring starts a keyring with seed as generation 1.
+func NewDerivedKeyring(seed string) *DerivedKeyring {
+	return &DerivedKeyring{generations: []keyGeneration{{version: 1, seed: []byte(seed)}}}
+}
+
+// AddGeneration loads an older generation, e.g. from config after a restart,
+// that stays valid for verification until notAfter.
+func (k *DerivedKeyring) AddGeneration(version int, seed string, notAfter time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if version >= k.generations[0].version {
+		return fmt.Errorf("keyring: generation %d is not older than the current one", version)
+	}
+	for _, g := range k.generations {
+		if g.version == version {
+			return fmt.Errorf("keyring: generation %d already exists", version)
+		}
+	}
+	i := 1
+	for i < len(k.generations) && k.generations[i].version > version {
+		i++
+	}
+	g := keyGeneration{version: version, seed: []byte(seed), notAfter: notAfter}
+	k.generations = append(k.generations[:i], append([]keyGeneration{g}, k.generations[i:]...)...)
+	return nil
+}
+
+// Rotate makes seed the current generation and returns its version.
+// The previous generations stay valid for verification for grace.
+func (k *DerivedKeyring) Rotate(seed string, grace time.Duration) int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	notAfter := k.now().Add(grace)
+	for i := range k.generations {
+		if g := &k.generations[i]; g.notAfter.IsZero() || g.notAfter.After(notAfter) {
+			g.notAfter = notAfter
+		}
+	}
+	version := k.generations[0].version + 1
+	k.generations = append([]keyGeneration{{version: version, seed: []byte(seed)}}, k.generations...)
+	return version
+}
+
+func (k *DerivedKeyring) now() time.Time {
+	if k.Now != nil {
+		return k.Now()
+	}
+	return time.Now()
+}
+
+// Current returns the signing key for purpose.
+func (k *DerivedKeyring) Current(purpose string) (DerivedKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return deriveKey(k.generations[0], purpose)
+}
+
+// Lookup returns the key of purpose and version if that generation is still valid.
+func (k *DerivedKeyring) Lookup(purpose string, version int) (DerivedKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := k.now()
+	for _, g := range k.generations {
+		if g.version != version {
+			continue
+		}
+		if !g.notAfter.IsZero() && now.After(g.notAfter) {
+			return DerivedKey{}, fmt.Errorf("%w: %s.v%d", ErrKeyExpired, purpose, version)
+		}
+		return deriveKey(g, purpose)
+	}
+	return DerivedKey{}, fmt.Errorf("%w: %s.v%d", ErrKeyNotFound, purpose, version)
+}
+
+// Key returns the key for an id made by DerivedKey.ID, see Lookup.
+func (k *DerivedKeyring) Key(id string) (DerivedKey, error) {
+	i := strings.LastIndex(id, ".v")
+	if i <= 0 {
+		return DerivedKey{}, fmt.Errorf("%w: malformed key id %q", ErrKeyNotFound, id)
+	}
+	version, err := strconv.Atoi(id[i+2:])
+	if err != nil {
+		return DerivedKey{}, fmt.Errorf("%w: malformed key id %q", ErrKeyNotFound, id)
+	}
+	return k.Lookup(id[:i], version)
+}
+
+// Verification returns every valid key of purpose, current first, for
+// checking values that carry no key id.
+func (k *DerivedKeyring) Verification(purpose string) ([]DerivedKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := k.now()
+	var keys []DerivedKey
+	for _, g := range k.generations {
+		if !g.notAfter.IsZero() && now.After(g.notAfter) {
+			continue
+		}
+		key, err := deriveKey(g, purpose)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// deriveKey runs HKDF-SHA256 over the generation seed, with purpose and
+// version as info so no two subkeys are alike.
+func deriveKey(g keyGeneration, purpose string) (DerivedKey, error) {
+	if purpose == "" {
+		return DerivedKey{}, fmt.Errorf("%w: empty purpose", ErrKeyNotFound)
+	}
+	info := fmt.Sprintf("%s.v%d", purpose, g.version)
+	key := make([]byte, derivedKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, g.seed, keyringSalt, []byte(info)), key); err != nil {
+		return DerivedKey{}, err
+	}
+	return DerivedKey{ID: info, Purpose: purpose, Version: g.version, Key: key}, nil
+}
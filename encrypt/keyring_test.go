@@ -0,0 +1,124 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// https://tools.ietf.org/html/rfc5869#appendix-A, the SHA-256 cases
+func TestHKDFRFC5869(t *testing.T) {
+	seq := func(from, to byte) []byte {
+		var b []byte
+		for i := from; i <= to; i++ {
+			b = append(b, i)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		name            string
+		ikm, salt, info []byte
+		prk, okm        string
+	}{
+		{"A.1", bytes.Repeat([]byte{0x0b}, 22), seq(0x00, 0x0c), seq(0xf0, 0xf9),
+			"077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5",
+			"3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"},
+		{"A.3", bytes.Repeat([]byte{0x0b}, 22), nil, nil,
+			"19ef24a32c717b167f33a91d6f648bdf96596776afdb6377ac434c1c293ccb04",
+			"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8"},
+	} {
+		if prk := hex.EncodeToString(hkdf.Extract(sha256.New, tc.ikm, tc.salt)); prk != tc.prk {
+			t.Errorf("%s: PRK %s", tc.name, prk)
+		}
+		okm := make([]byte, 42)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, tc.ikm, tc.salt, tc.info), okm); err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(okm) != tc.okm {
+			t.Errorf("%s: OKM %x", tc.name, okm)
+		}
+	}
+}
+
+func TestDerivedKeyring(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ring := NewDerivedKeyring("seed")
+	ring.Now = func() time.Time { return now }
+
+	// pinned so a change to the salt or info layout cannot go unnoticed,
+	// it would invalidate every key handed out so far
+	key, err := ring.Current(PurposeWebhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "webhook-signing.v1" || hex.EncodeToString(key.Key) != "1034ef3bde5dd4435b99851f661b2e75e362c4a59b7a9c8c17b64c89adce89c7" {
+		t.Fatalf("derived %s %x", key.ID, key.Key)
+	}
+	cookie, _ := ring.Current(PurposeCookie)
+	if bytes.Equal(cookie.Key, key.Key) {
+		t.Fatal("purposes share a key")
+	}
+	if _, err := ring.Current(""); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("empty purpose: %v", err)
+	}
+
+	if v := ring.Rotate("seed-2", time.Hour); v != 2 {
+		t.Fatalf("rotated to %d", v)
+	}
+	current, _ := ring.Current(PurposeWebhook)
+	if current.ID != "webhook-signing.v2" || bytes.Equal(current.Key, key.Key) {
+		t.Fatalf("after rotate %s", current.ID)
+	}
+	if old, err := ring.Key(key.ID); err != nil || !bytes.Equal(old.Key, key.Key) {
+		t.Fatalf("old generation in grace: %v", err)
+	}
+	if keys, _ := ring.Verification(PurposeWebhook); len(keys) != 2 || keys[0].Version != 2 {
+		t.Fatalf("verification keys %+v", keys)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := ring.Key(key.ID); !errors.Is(err, ErrKeyExpired) {
+		t.Fatalf("old generation after grace: %v", err)
+	}
+	if keys, _ := ring.Verification(PurposeWebhook); len(keys) != 1 {
+		t.Fatalf("%d verification keys after grace", len(keys))
+	}
+	for _, id := range []string{"webhook-signing.v9", "webhook-signing", "webhook-signing.vx", ".v1"} {
+		if _, err := ring.Key(id); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+}
+
+func TestDerivedKeyringAddGeneration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ring := NewDerivedKeyring("seed-1")
+	ring.Now = func() time.Time { return now }
+	ring.Rotate("seed-2", time.Hour)
+	if err := ring.AddGeneration(0, "seed-0", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.AddGeneration(1, "seed-1", now.Add(time.Hour)); err == nil {
+		t.Fatal("duplicate generation added")
+	}
+	if err := ring.AddGeneration(2, "seed-x", now.Add(time.Hour)); err == nil {
+		t.Fatal("generation as new as the current one added")
+	}
+	keys, err := ring.Verification(PurposeSignedURL)
+	if err != nil || len(keys) != 3 || keys[0].Version != 2 || keys[1].Version != 1 || keys[2].Version != 0 {
+		t.Fatalf("verification keys %+v %v", keys, err)
+	}
+	now = now.Add(30 * time.Minute)
+	if _, err := ring.Lookup(PurposeSignedURL, 0); !errors.Is(err, ErrKeyExpired) {
+		t.Fatalf("added generation after notAfter: %v", err)
+	}
+	if _, err := ring.Lookup(PurposeSignedURL, 1); err != nil {
+		t.Fatalf("rotated generation in grace: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrOTPInvalid is returned when a code does not match within the window.
+	ErrOTPInvalid = errors.New("otp: invalid code")
+	// ErrOTPReplayed is returned when a code matches a counter or time step already used.
+	ErrOTPReplayed = errors.New("otp: code already used")
+	// ErrOTPSecret is returned for a secret shorter than otpMinSecretSize.
+	ErrOTPSecret = errors.New("otp: secret shorter than 128 bits")
+)
+
+// otpMinSecretSize is the 128 bit minimum of https://tools.ietf.org/html/rfc4226#section-4
+const otpMinSecretSize = 16
+
+// otpAlgorithmNames are the otpauth:// spellings of the supported HMAC algorithms.
+var otpAlgorithmNames = map[string]string{
+	HmacSHA1:   "SHA1",
+	HmacSHA256: "SHA256",
+	HmacSHA512: "SHA512",
+}
+
+var otpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateOTPSecret returns a random 160 bit secret, the size RFC 4226 recommends.
+func GenerateOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// OTPSecretFromBase32 decodes a secret as shown to users, ignoring case, spaces and padding.
+func OTPSecretFromBase32(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	return otpBase32.DecodeString(strings.TrimRight(s, "="))
+}
+
+// otpCode computes the truncated HMAC of counter, https://tools.ietf.org/html/rfc4226#section-5.3
+func otpCode(secret []byte, counter uint64, digits int, alg string) (string, error) {
+	if len(secret) < otpMinSecretSize {
+		return "", ErrOTPSecret
+	}
+	if digits == 0 {
+		digits = 6
+	}
+	if digits < 6 || digits > 10 {
+		return "", fmt.Errorf("otp: %d digits not supported", digits)
+	}
+	if alg == "" {
+		alg = HmacSHA1
+	}
+	if _, ok := otpAlgorithmNames[alg]; !ok {
+		return "", fmt.Errorf("otp: unsupported algorithm %q", alg)
+	}
+	mac, err := HmacNew(alg, secret)
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	bin := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, bin%mod), nil
+}
+
+func otpEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// otpURI builds a key URI as understood by authenticator apps.
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func otpURI(kind string, issuer string, account string, secret []byte, alg string, digits int, extra url.Values) string {
+	if alg == "" {
+		alg = HmacSHA1
+	}
+	if digits == 0 {
+		digits = 6
+	}
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+	q := url.Values{}
+	q.Set("secret", otpBase32.EncodeToString(secret))
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", otpAlgorithmNames[alg])
+	q.Set("digits", strconv.Itoa(digits))
+	for k, v := range extra {
+		q[k] = v
+	}
+	// apps expect %20 rather than + for spaces
+	u := url.URL{Scheme: "otpauth", Host: kind, Path: "/" + label, RawQuery: strings.ReplaceAll(q.Encode(), "+", "%20")}
+	return u.String()
+}
+
+// HOTP is a counter based one-time password, https://tools.ietf.org/html/rfc4226
+type HOTP struct {
+	Secret    []byte // at least 16 bytes, GenerateOTPSecret makes 20
+	Digits    int    // 6 by default
+	Algorithm string // HmacSHA1 by default, which every authenticator app supports
+	// LookAhead is how many counters past the expected one are accepted,
+	// to resync after codes were generated but never used.
+	LookAhead int
+}
+
+func (h HOTP) Generate(counter uint64) (string, error) {
+	return otpCode(h.Secret, counter, h.Digits, h.Algorithm)
+}
+
+// Verify checks code against counter up to counter+LookAhead, where counter
+// is the next unused one. It returns the counter to store for the next call,
+// so a code can never be accepted twice.
+func (h HOTP) Verify(code string, counter uint64) (uint64, error) {
+	for i := uint64(0); i <= uint64(h.LookAhead); i++ {
+		want, err := otpCode(h.Secret, counter+i, h.Digits, h.Algorithm)
+		if err != nil {
+			return counter, err
+		}
+		if otpEqual(code, want) {
+			return counter + i + 1, nil
+		}
+	}
+	return counter, ErrOTPInvalid
+}
+
+// URI returns the otpauth:// provisioning URI, starting at counter.
+func (h HOTP) URI(issuer string, account string, counter uint64) string {
+	return otpURI("hotp", issuer, account, h.Secret, h.Algorithm, h.Digits,
+		url.Values{"counter": {strconv.FormatUint(counter, 10)}})
+}
+
+// TOTP is a time based one-time password, https://tools.ietf.org/html/rfc6238
+type TOTP struct {
+	Secret    []byte        // at least 16 bytes, GenerateOTPSecret makes 20
+	Digits    int           // 6 by default
+	Algorithm string        // HmacSHA1 by default
+	Period    time.Duration // 30 seconds by default
+	// Skew is how many time steps before and after the current one are
+	// accepted, to allow for clock drift. 1 by default, negative for none.
+	Skew int
+	Now  func() time.Time
+}
+
+func (t TOTP) period() time.Duration {
+	if t.Period < time.Second {
+		return 30 * time.Second
+	}
+	return t.Period
+}
+
+// Step returns the time step at tm.
+func (t TOTP) Step(tm time.Time) uint64 {
+	return uint64(tm.Unix()) / uint64(t.period()/time.Second)
+}
+
+// Generate returns the code for the current time.
+func (t TOTP) Generate() (string, error) {
+	return t.GenerateAt(t.now())
+}
+
+func (t TOTP) GenerateAt(tm time.Time) (string, error) {
+	return otpCode(t.Secret, t.Step(tm), t.Digits, t.Algorithm)
+}
+
+func (t TOTP) now() time.Time {
+	if t.Now != nil {
+		return t.Now()
+	}
+	return time.Now()
+}
+
+// Verify checks code around the current time step and returns the step it
+// matched. Steps up to lastStep, the one returned by the previous successful
+// call, are refused so a code can't be replayed within its window.
+func (t TOTP) Verify(code string, lastStep uint64) (uint64, error) {
+	skew := t.Skew
+	switch {
+	case skew == 0:
+		skew = 1
+	case skew < 0:
+		skew = 0
+	}
+	current := t.Step(t.now())
+	replayed := false
+	for i := -skew; i <= skew; i++ {
+		if i < 0 && current < uint64(-i) {
+			continue
+		}
+		step := current + uint64(i)
+		want, err := otpCode(t.Secret, step, t.Digits, t.Algorithm)
+		if err != nil {
+			return 0, err
+		}
+		if !otpEqual(code, want) {
+			continue
+		}
+		if step <= lastStep {
+			replayed = true
+			continue
+		}
+		return step, nil
+	}
+	if replayed {
+		return 0, ErrOTPReplayed
+	}
+	return 0, ErrOTPInvalid
+}
+
+// URI returns the otpauth:// provisioning URI.
+func (t TOTP) URI(issuer string, account string) string {
+	return otpURI("totp", issuer, account, t.Secret, t.Algorithm, t.Digits,
+		url.Values{"period": {strconv.Itoa(int(t.period() / time.Second))}})
+}
@@ -0,0 +1,133 @@
+package encrypt
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// https://tools.ietf.org/html/rfc4226#appendix-D
+func TestHOTPRFC4226(t *testing.T) {
+	h := HOTP{Secret: []byte("12345678901234567890")}
+	for counter, want := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		got, err := h.Generate(uint64(counter))
+		if err != nil || got != want {
+			t.Errorf("counter %d: %s %v, want %s", counter, got, err, want)
+		}
+	}
+
+	h.LookAhead = 2
+	next, err := h.Verify("359152", 0)
+	if err != nil || next != 3 {
+		t.Fatalf("look ahead: %d %v", next, err)
+	}
+	if _, err := h.Verify("359152", next); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("used code accepted again: %v", err)
+	}
+	if _, err := h.Verify("520489", next); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("code past the look ahead: %v", err)
+	}
+}
+
+// https://tools.ietf.org/html/rfc6238#appendix-B
+func TestTOTPRFC6238(t *testing.T) {
+	secrets := map[string][]byte{
+		HmacSHA1:   []byte("12345678901234567890"),
+		HmacSHA256: []byte("12345678901234567890123456789012"),
+		HmacSHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	for _, tc := range []struct {
+		unix                 int64
+		sha1, sha256, sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	} {
+		for alg, want := range map[string]string{HmacSHA1: tc.sha1, HmacSHA256: tc.sha256, HmacSHA512: tc.sha512} {
+			totp := TOTP{Secret: secrets[alg], Digits: 8, Algorithm: alg}
+			if got, err := totp.GenerateAt(time.Unix(tc.unix, 0)); err != nil || got != want {
+				t.Errorf("%d %s: %s %v, want %s", tc.unix, alg, got, err, want)
+			}
+		}
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	totp := TOTP{Secret: []byte("12345678901234567890"), Digits: 8, Now: func() time.Time { return now }}
+	code := "89005924"
+
+	step, err := totp.Verify(code, 0)
+	if err != nil || step != totp.Step(now) {
+		t.Fatalf("verify: %d %v", step, err)
+	}
+	if _, err := totp.Verify(code, step); !errors.Is(err, ErrOTPReplayed) {
+		t.Fatalf("replay: %v", err)
+	}
+	// one step of drift is allowed by default, two are not
+	now = now.Add(30 * time.Second)
+	if _, err := totp.Verify(code, 0); err != nil {
+		t.Fatalf("previous step: %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := totp.Verify(code, 0); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("two steps old: %v", err)
+	}
+	totp.Skew = -1
+	now = now.Add(-30 * time.Second)
+	if _, err := totp.Verify(code, 0); !errors.Is(err, ErrOTPInvalid) {
+		t.Fatalf("drift with skew disabled: %v", err)
+	}
+}
+
+func TestOTPShortSecret(t *testing.T) {
+	short := []byte("0123456789abcde")
+	if _, err := (HOTP{Secret: short}).Generate(0); !errors.Is(err, ErrOTPSecret) {
+		t.Fatalf("HOTP generate: %v", err)
+	}
+	if _, err := (HOTP{Secret: short}).Verify("000000", 0); !errors.Is(err, ErrOTPSecret) {
+		t.Fatalf("HOTP verify: %v", err)
+	}
+	if _, err := (TOTP{}).Generate(); !errors.Is(err, ErrOTPSecret) {
+		t.Fatalf("TOTP generate without a secret: %v", err)
+	}
+	if _, err := (TOTP{Secret: short}).Verify("000000", 0); !errors.Is(err, ErrOTPSecret) {
+		t.Fatalf("TOTP verify: %v", err)
+	}
+	secret, err := GenerateOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (TOTP{Secret: secret}).Generate(); err != nil {
+		t.Fatalf("generated secret: %v", err)
+	}
+}
+
+func TestOTPURI(t *testing.T) {
+	secret, err := OTPSecretFromBase32("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil || string(secret) != "12345678901234567890" {
+		t.Fatalf("base32 secret %q %v", secret, err)
+	}
+	uri := TOTP{Secret: secret}.URI("Example Co", "alice@example.com")
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Example Co:alice@example.com" ||
+		q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("period") != "30" || q.Get("algorithm") != "SHA1" {
+		t.Fatalf("uri %s", uri)
+	}
+	if strings.Contains(uri, "+") {
+		t.Fatalf("space encoded as +: %s", uri)
+	}
+	if hotp := (HOTP{Secret: secret, Digits: 8}).URI("", "bob", 7); !strings.Contains(hotp, "counter=7") || !strings.Contains(hotp, "digits=8") {
+		t.Fatalf("hotp uri %s", hotp)
+	}
+}
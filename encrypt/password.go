@@ -0,0 +1,293 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Password hashes are stored as PHC strings, which carry the algorithm and
+// its parameters so they can be verified after the defaults change.
+// https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//	$scrypt$ln=17,r=8,p=1$<salt>$<hash>
+//	$2a$12$<salt and hash>, bcrypt keeps its own modular crypt format
+
+// Password hashing algorithms.
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+	PasswordScrypt   = "scrypt"
+)
+
+var (
+	// ErrPasswordMismatch is returned when a secret does not match its hash.
+	ErrPasswordMismatch = errors.New("password: secret does not match")
+	// ErrPasswordHash is returned for a hash that can't be parsed.
+	ErrPasswordHash = errors.New("password: malformed hash")
+)
+
+var phcBase64 = base64.RawStdEncoding
+
+// Upper bounds for parameters read from stored hashes, so a planted hash
+// can't make a single verification take gigabytes or minutes.
+const (
+	maxArgon2idMemory     = 1 << 20 // KiB, 1 GiB
+	maxArgon2idIterations = 64
+	maxScryptMemory       = 1 << 30 // bytes, 128 * N * r
+	maxScryptParallelism  = 16
+	maxPasswordHashLen    = 64
+)
+
+// Argon2idParams tune argon2id, https://tools.ietf.org/html/rfc9106#section-4
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// ScryptParams tune scrypt, https://tools.ietf.org/html/rfc7914#section-2
+type ScryptParams struct {
+	LogN int // N = 2^LogN
+	R    int
+	P    int
+}
+
+// PasswordHasher hashes secrets with Algorithm and the parameters set for it.
+type PasswordHasher struct {
+	Algorithm  string // PasswordArgon2id by default
+	Argon2id   Argon2idParams
+	Scrypt     ScryptParams
+	BcryptCost int
+}
+
+// NewPasswordHasher returns an argon2id hasher with the second recommended
+// option of RFC 9106, and scrypt and bcrypt defaults of similar cost.
+func NewPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  PasswordArgon2id,
+		Argon2id:   Argon2idParams{Memory: 64 * 1024, Iterations: 3, Parallelism: 4},
+		Scrypt:     ScryptParams{LogN: 17, R: 8, P: 1},
+		BcryptCost: 12,
+	}
+}
+
+var defaultPasswordHasher = NewPasswordHasher()
+
+// HashPassword hashes secret with the default hasher.
+func HashPassword(secret string) (string, error) {
+	return defaultPasswordHasher.Hash(secret)
+}
+
+// VerifyPassword checks secret against a hash made by any PasswordHasher.
+func VerifyPassword(secret string, encoded string) error {
+	_, err := defaultPasswordHasher.Verify(secret, encoded)
+	return err
+}
+
+// IsPasswordHash reports whether s looks like a hash this package can verify,
+// rather than a plaintext secret.
+func IsPasswordHash(s string) bool {
+	for _, prefix := range []string{"$argon2id$", "$scrypt$", "$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func passwordSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// Hash returns the PHC string of secret under a random salt.
+func (h *PasswordHasher) Hash(secret string) (string, error) {
+	switch h.algorithm() {
+	case PasswordArgon2id:
+		salt, err := passwordSalt()
+		if err != nil {
+			return "", err
+		}
+		p := h.Argon2id
+		if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+			return "", fmt.Errorf("password: argon2id parameters not set")
+		}
+		key := argon2.IDKey([]byte(secret), salt, p.Iterations, p.Memory, p.Parallelism, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			phcBase64.EncodeToString(salt), phcBase64.EncodeToString(key)), nil
+	case PasswordScrypt:
+		salt, err := passwordSalt()
+		if err != nil {
+			return "", err
+		}
+		p := h.Scrypt
+		key, err := scryptKey(secret, salt, p, 32)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", p.LogN, p.R, p.P,
+			phcBase64.EncodeToString(salt), phcBase64.EncodeToString(key)), nil
+	case PasswordBcrypt:
+		// secrets over 72 bytes are refused, bcrypt would ignore the rest
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", fmt.Errorf("password: unsupported algorithm %q", h.Algorithm)
+}
+
+func (h *PasswordHasher) algorithm() string {
+	if h.Algorithm == "" {
+		return PasswordArgon2id
+	}
+	return h.Algorithm
+}
+
+func scryptKey(secret string, salt []byte, p ScryptParams, keyLen int) ([]byte, error) {
+	if p.LogN < 1 || p.LogN > 30 {
+		return nil, fmt.Errorf("password: scrypt ln=%d out of range", p.LogN)
+	}
+	return scrypt.Key([]byte(secret), salt, 1<<p.LogN, p.R, p.P, keyLen)
+}
+
+// Verify checks secret against encoded in constant time. rehash reports that
+// the secret matched but encoded was made with another algorithm or other
+// parameters than h, so it should be replaced with h.Hash(secret).
+func (h *PasswordHasher) Verify(secret string, encoded string) (rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, want, err := parseArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		got := argon2.IDKey([]byte(secret), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(want)))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return false, ErrPasswordMismatch
+		}
+		return h.algorithm() != PasswordArgon2id || p != h.Argon2id, nil
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		p, salt, want, err := parseScrypt(encoded)
+		if err != nil {
+			return false, err
+		}
+		got, err := scryptKey(secret, salt, p, len(want))
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return false, ErrPasswordMismatch
+		}
+		return h.algorithm() != PasswordScrypt || p != h.Scrypt, nil
+	case IsPasswordHash(encoded):
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, ErrPasswordHash
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(secret)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrPasswordMismatch
+			}
+			return false, err
+		}
+		return h.algorithm() != PasswordBcrypt || cost != h.BcryptCost, nil
+	}
+	return false, ErrPasswordHash
+}
+
+// NeedsRehash reports whether encoded differs from what h.Hash would produce,
+// without the cost of verifying a secret.
+func (h *PasswordHasher) NeedsRehash(encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, _, _, err := parseArgon2id(encoded)
+		return err != nil || h.algorithm() != PasswordArgon2id || p != h.Argon2id
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		p, _, _, err := parseScrypt(encoded)
+		return err != nil || h.algorithm() != PasswordScrypt || p != h.Scrypt
+	case IsPasswordHash(encoded):
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || h.algorithm() != PasswordBcrypt || cost != h.BcryptCost
+	}
+	return true
+}
+
+// phcParams parses "k=v,k=v" into integers.
+func phcParams(s string) (map[string]int, error) {
+	params := make(map[string]int)
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, ErrPasswordHash
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, ErrPasswordHash
+		}
+		params[k] = n
+	}
+	return params, nil
+}
+
+// phcSaltHash decodes the trailing salt and hash fields.
+func phcSaltHash(salt string, hash string) ([]byte, []byte, error) {
+	s, err := phcBase64.DecodeString(salt)
+	if err != nil {
+		return nil, nil, ErrPasswordHash
+	}
+	h, err := phcBase64.DecodeString(hash)
+	if err != nil || len(h) < 16 || len(h) > maxPasswordHashLen {
+		return nil, nil, ErrPasswordHash
+	}
+	return s, h, nil
+}
+
+func parseArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Argon2idParams{}, nil, nil, ErrPasswordHash
+	}
+	params, err := phcParams(fields[3])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, err
+	}
+	p := Argon2idParams{Memory: uint32(params["m"]), Iterations: uint32(params["t"]), Parallelism: uint8(params["p"])}
+	if p.Iterations == 0 || p.Parallelism == 0 || params["p"] > 255 || p.Memory < 8*uint32(p.Parallelism) ||
+		params["m"] > maxArgon2idMemory || params["t"] > maxArgon2idIterations {
+		return Argon2idParams{}, nil, nil, ErrPasswordHash
+	}
+	salt, hash, err := phcSaltHash(fields[4], fields[5])
+	return p, salt, hash, err
+}
+
+func parseScrypt(encoded string) (ScryptParams, []byte, []byte, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 5 {
+		return ScryptParams{}, nil, nil, ErrPasswordHash
+	}
+	params, err := phcParams(fields[2])
+	if err != nil {
+		return ScryptParams{}, nil, nil, err
+	}
+	p := ScryptParams{LogN: params["ln"], R: params["r"], P: params["p"]}
+	if p.LogN < 1 || p.LogN > 30 || p.R < 1 || p.P < 1 || p.P > maxScryptParallelism ||
+		p.R > (maxScryptMemory/128)>>p.LogN {
+		return ScryptParams{}, nil, nil, ErrPasswordHash
+	}
+	salt, hash, err := phcSaltHash(fields[3], fields[4])
+	return p, salt, hash, err
+}
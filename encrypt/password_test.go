@@ -0,0 +1,122 @@
+package encrypt
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cheap parameters, the round trips only have to be real, not slow
+func testPasswordHasher(alg string) *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  alg,
+		Argon2id:   Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1},
+		Scrypt:     ScryptParams{LogN: 4, R: 8, P: 1},
+		BcryptCost: 4,
+	}
+}
+
+// https://tools.ietf.org/html/rfc7914#section-12, the third vector
+func TestScryptRFC7914(t *testing.T) {
+	want, _ := hex.DecodeString("fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+		"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640")
+	encoded := fmt.Sprintf("$scrypt$ln=10,r=8,p=16$%s$%s", phcBase64.EncodeToString([]byte("NaCl")), phcBase64.EncodeToString(want))
+	h := testPasswordHasher(PasswordScrypt)
+	if _, err := h.Verify("password", encoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Verify("Password", encoded); !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("wrong password: %v", err)
+	}
+}
+
+func TestPasswordRoundTrip(t *testing.T) {
+	for _, alg := range []string{PasswordArgon2id, PasswordScrypt, PasswordBcrypt} {
+		h := testPasswordHasher(alg)
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsPasswordHash(encoded) {
+			t.Fatalf("%s: %s not recognised", alg, encoded)
+		}
+		if rehash, err := h.Verify("correct horse", encoded); err != nil || rehash {
+			t.Fatalf("%s: %v rehash=%v", alg, err, rehash)
+		}
+		if _, err := h.Verify("correct horse!", encoded); !errors.Is(err, ErrPasswordMismatch) {
+			t.Fatalf("%s: wrong secret: %v", alg, err)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Fatalf("%s: own hash needs rehash", alg)
+		}
+		// every other hasher still verifies it and asks for a rehash
+		for _, other := range []string{PasswordArgon2id, PasswordScrypt, PasswordBcrypt} {
+			if other == alg {
+				continue
+			}
+			if rehash, err := testPasswordHasher(other).Verify("correct horse", encoded); err != nil || !rehash {
+				t.Fatalf("%s hash with %s hasher: %v rehash=%v", alg, other, err, rehash)
+			}
+		}
+	}
+
+	h := testPasswordHasher(PasswordArgon2id)
+	encoded, _ := h.Hash("s")
+	h.Argon2id.Iterations = 2
+	if !h.NeedsRehash(encoded) {
+		t.Fatal("changed parameters do not need a rehash")
+	}
+	if IsPasswordHash("plain-secret") {
+		t.Fatal("plaintext taken for a hash")
+	}
+}
+
+func TestPasswordHashLimits(t *testing.T) {
+	salt := phcBase64.EncodeToString(make([]byte, 16))
+	hash := phcBase64.EncodeToString(make([]byte, 32))
+	for _, tc := range []struct {
+		name    string
+		encoded string
+	}{
+		{"argon2id 4 GiB", "$argon2id$v=19$m=4194304,t=1,p=1$" + salt + "$" + hash},
+		{"argon2id 1000 passes", "$argon2id$v=19$m=65536,t=1000,p=1$" + salt + "$" + hash},
+		{"argon2id no passes", "$argon2id$v=19$m=65536,t=0,p=1$" + salt + "$" + hash},
+		{"argon2id other version", "$argon2id$v=16$m=65536,t=3,p=4$" + salt + "$" + hash},
+		{"argon2id long output", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + phcBase64.EncodeToString(make([]byte, 1<<20))},
+		{"scrypt 2 GiB", "$scrypt$ln=20,r=16,p=1$" + salt + "$" + hash},
+		{"scrypt huge N", "$scrypt$ln=40,r=1,p=1$" + salt + "$" + hash},
+		{"scrypt no r", "$scrypt$ln=10,p=1$" + salt + "$" + hash},
+		{"scrypt r 0", "$scrypt$ln=10,r=0,p=1$" + salt + "$" + hash},
+		{"scrypt p 1000", "$scrypt$ln=10,r=8,p=1000$" + salt + "$" + hash},
+		{"scrypt p 0", "$scrypt$ln=10,r=8,p=0$" + salt + "$" + hash},
+		{"short hash", "$scrypt$ln=10,r=8,p=1$" + salt + "$" + phcBase64.EncodeToString(make([]byte, 8))},
+		{"negative", "$scrypt$ln=10,r=-8,p=1$" + salt + "$" + hash},
+		{"missing field", "$scrypt$ln=10,r=8,p=1$" + salt},
+		{"unknown", "$pbkdf2$i=1000$" + salt + "$" + hash},
+	} {
+		if _, err := testPasswordHasher("").Verify("x", tc.encoded); !errors.Is(err, ErrPasswordHash) {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !testPasswordHasher("").NeedsRehash(tc.encoded) {
+			t.Errorf("%s: no rehash needed", tc.name)
+		}
+	}
+	// the largest accepted parameters are still parsed
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=1048576,t=64,p=255$" + salt + "$" + hash,
+		"$scrypt$ln=20,r=8,p=16$" + salt + "$" + hash,
+		"$scrypt$ln=23,r=1,p=1$" + salt + "$" + hash,
+	} {
+		var err error
+		if strings.HasPrefix(encoded, "$argon2id$") {
+			_, _, _, err = parseArgon2id(encoded)
+		} else {
+			_, _, _, err = parseScrypt(encoded)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrPrivateKeyFormat is returned for data that holds no private key we can parse.
+var ErrPrivateKeyFormat = errors.New("private key: unrecognized format")
+
+// ParsePrivateKey reads a private key from PEM or DER. It understands
+// PKCS#8 ("PRIVATE KEY"), encrypted PKCS#8 ("ENCRYPTED PRIVATE KEY", PBES2),
+// PKCS#1 ("RSA PRIVATE KEY") and SEC1 ("EC PRIVATE KEY"). The encoding is
+// detected from the content rather than the PEM label, so files labelled
+// "ECDSA PRIVATE KEY" by earlier versions of this library still load.
+// Other blocks, such as "EC PARAMETERS" from `openssl ecparam`, are skipped.
+// passphrase is only used for encrypted keys.
+func ParsePrivateKey(data []byte, passphrase []byte) (crypto.Signer, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return parsePrivateKeyDER(data, passphrase)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, ErrPrivateKeyFormat
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if _, ok := block.Headers["DEK-Info"]; ok {
+			return nil, errors.New("private key: legacy PEM encryption is not supported, convert with `openssl pkcs8 -topk8`")
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			return parseEncryptedPKCS8(block.Bytes, passphrase)
+		}
+		return parsePrivateKeyDER(block.Bytes, passphrase)
+	}
+}
+
+// parsePrivateKeyDER tries every known encoding in turn.
+func parsePrivateKeyDER(der []byte, passphrase []byte) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	if key, err = x509.ParsePKCS8PrivateKey(der); err == nil {
+		return privateKeySigner(key)
+	}
+	if key, err = x509.ParsePKCS1PrivateKey(der); err == nil {
+		return privateKeySigner(key)
+	}
+	if key, err = x509.ParseECPrivateKey(der); err == nil {
+		return privateKeySigner(key)
+	}
+	return parseEncryptedPKCS8(der, passphrase)
+}
+
+func parseEncryptedPKCS8(der []byte, passphrase []byte) (crypto.Signer, error) {
+	decrypted, err := decryptPKCS8(der, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(decrypted)
+	if err != nil {
+		// the padding happened to check out, the key did not
+		return nil, ErrIncorrectPassphrase
+	}
+	return privateKeySigner(key)
+}
+
+// privateKeySigner rejects keys that can't sign, such as X25519.
+func privateKeySigner(key interface{}) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key: %T can't sign", key)
+	}
+	return signer, nil
+}
+
+// MarshalPrivateKeyPEM encodes key as PKCS#8, encrypted with PBES2
+// (PBKDF2-HMAC-SHA256, AES-256-CBC) when passphrase is not empty.
+func MarshalPrivateKeyPEM(key crypto.PrivateKey, passphrase []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	encrypted, err := encryptPKCS8(der, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}), nil
+}
+
+// LoadPrivateKeyFile is ParsePrivateKey for a file.
+func LoadPrivateKeyFile(path string, passphrase []byte) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// WritePrivateKeyFile writes MarshalPrivateKeyPEM to path, readable by the owner only.
+func WritePrivateKeyFile(path string, key crypto.PrivateKey, passphrase []byte) error {
+	data, err := MarshalPrivateKeyPEM(key, passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,225 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted PKCS#8 with PBES2, as written by `openssl pkcs8 -topk8 -v2 aes-256-cbc`.
+// https://tools.ietf.org/html/rfc5958#section-3
+// https://tools.ietf.org/html/rfc8018#section-6.2
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11} // https://tools.ietf.org/html/rfc7914#section-7
+	oidHmacWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHmacWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHmacWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHmacWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// pkcs8Iterations is the PBKDF2-HMAC-SHA256 count used when encrypting, as
+// recommended by OWASP. Keys are decrypted once per process, so it is affordable.
+const pkcs8Iterations = 600000
+
+var (
+	// ErrPassphraseRequired is returned for an encrypted key when no passphrase was given.
+	ErrPassphraseRequired = errors.New("private key: passphrase required")
+	// ErrIncorrectPassphrase is returned when an encrypted key does not decrypt.
+	ErrIncorrectPassphrase = errors.New("private key: incorrect passphrase")
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+func pbes2Cipher(oid asn1.ObjectIdentifier) (int, bool) {
+	switch {
+	case oid.Equal(oidAES128CBC):
+		return 16, true
+	case oid.Equal(oidAES192CBC):
+		return 24, true
+	case oid.Equal(oidAES256CBC):
+		return 32, true
+	}
+	return 0, false
+}
+
+func pbkdf2Hash(prf pkix.AlgorithmIdentifier) (func() hash.Hash, error) {
+	switch {
+	// SHA-1 is the default when the PRF is omitted
+	case len(prf.Algorithm) == 0, prf.Algorithm.Equal(oidHmacWithSHA1):
+		return sha1.New, nil
+	case prf.Algorithm.Equal(oidHmacWithSHA256):
+		return sha256.New, nil
+	case prf.Algorithm.Equal(oidHmacWithSHA384):
+		return sha512.New384, nil
+	case prf.Algorithm.Equal(oidHmacWithSHA512):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("private key: unsupported PBKDF2 PRF %v", prf.Algorithm)
+}
+
+// pbes2Key derives the content encryption key from the KDF parameters.
+func pbes2Key(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("private key: PBKDF2 key length does not match the cipher")
+		}
+		if params.IterationCount < 1 {
+			return nil, errors.New("private key: invalid PBKDF2 iteration count")
+		}
+		h, err := pbkdf2Hash(params.PRF)
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, keyLen, h), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("private key: scrypt key length does not match the cipher")
+		}
+		return scrypt.Key(passphrase, params.Salt, params.CostParameter, params.BlockSize, params.ParallelizationParameter, keyLen)
+	}
+	return nil, fmt.Errorf("private key: unsupported key derivation %v", kdf.Algorithm)
+}
+
+// decryptPKCS8 returns the PKCS#8 DER inside an EncryptedPrivateKeyInfo.
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, ErrPrivateKeyFormat
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("private key: unsupported encryption %v, only PBES2 is", info.Algorithm.Algorithm)
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	keyLen, ok := pbes2Cipher(params.EncryptionScheme.Algorithm)
+	if !ok {
+		return nil, fmt.Errorf("private key: unsupported cipher %v", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, ErrPrivateKeyFormat
+	}
+	key, err := pbes2Key(params.KeyDerivationFunc, passphrase, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrPrivateKeyFormat
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	// bad padding is the usual sign of a wrong passphrase
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, ErrIncorrectPassphrase
+	}
+	if !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// encryptPKCS8 wraps PKCS#8 DER with PBES2, PBKDF2-HMAC-SHA256 and AES-256-CBC.
+func encryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key(passphrase, salt, pkcs8Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(append([]byte(nil), der...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	data := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, plain)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHmacWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
@@ -0,0 +1,202 @@
+package encrypt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// A crypto.Signer backed by a signing service over HTTP, so keys can live in
+// a separate daemon. The wire format is small and our own:
+//
+//	GET  {base}/keys/{id}       -> JWK of the public key
+//	POST {base}/keys/{id}/sign  {"hash":"SHA-256","pss":false,"digest":"<b64url>"}
+//	                            -> {"signature":"<b64url>"}
+//
+// Ed25519 keys sign the whole message, sent as "message" with an empty hash.
+// Signatures are returned exactly as crypto.Signer would, ECDSA in ASN.1 DER.
+
+type remoteSignRequest struct {
+	Hash    string `json:"hash,omitempty"`
+	Pss     bool   `json:"pss,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type remoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+// RemoteSigner implements crypto.Signer on top of a remote signing service.
+type RemoteSigner struct {
+	BaseURL string
+	KeyID   string
+	Token   string        // optional bearer token for the signing service
+	Client  *resty.Client // times out after 30 seconds unless replaced
+
+	public crypto.PublicKey
+}
+
+const defaultRemoteSignerTimeout = 30 * time.Second
+
+// NewRemoteSigner fetches the public key of keyID and returns a ready signer.
+func NewRemoteSigner(baseURL string, keyID string, token string) (*RemoteSigner, error) {
+	s := &RemoteSigner{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		KeyID:   keyID,
+		Token:   token,
+		Client:  resty.New().SetTimeout(defaultRemoteSignerTimeout),
+	}
+	var jwk JWK
+	resp, err := s.request().Get(s.BaseURL + "/keys/" + keyID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("remote signer: fetching key %s: %s", keyID, resp.Status())
+	}
+	if err := json.Unmarshal(resp.Body(), &jwk); err != nil {
+		return nil, err
+	}
+	if jwk.IsPrivate() {
+		return nil, errors.New("remote signer: service returned a private key")
+	}
+	s.public = jwk.Key
+	return s, nil
+}
+
+func (s *RemoteSigner) request() *resty.Request {
+	req := s.Client.R()
+	if s.Token != "" {
+		req.SetAuthToken(s.Token)
+	}
+	return req
+}
+
+func (s *RemoteSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *RemoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	body := remoteSignRequest{}
+	if _, ok := s.public.(ed25519.PublicKey); ok {
+		if opts.HashFunc() != 0 {
+			return nil, errors.New("remote signer: Ed25519 signs the message, not a digest")
+		}
+		body.Message = base64.RawURLEncoding.EncodeToString(digest)
+	} else {
+		body.Hash = opts.HashFunc().String()
+		_, body.Pss = opts.(*rsa.PSSOptions)
+		body.Digest = base64.RawURLEncoding.EncodeToString(digest)
+	}
+	var result remoteSignResponse
+	resp, err := s.request().
+		SetHeader("Content-Type", "application/json").
+		SetBody(&body).
+		Post(s.BaseURL + "/keys/" + s.KeyID + "/sign")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("remote signer: %s: %s", resp.Status(), strings.TrimSpace(string(resp.Body())))
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(result.Signature)
+}
+
+// remoteSignHashes are the digests a signing request may name.
+var remoteSignHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// RemoteSignerHandler is the reference server side of RemoteSigner, serving
+// keys by id. If token is not empty requests must carry it as a bearer token.
+func RemoteSignerHandler(keys map[string]crypto.Signer, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		path, ok := strings.CutPrefix(r.URL.Path, "/keys/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		id, sign := strings.CutSuffix(path, "/sign")
+		key, ok := keys[id]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !sign {
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			json.NewEncoder(w).Encode(JWK{Key: key.Public(), KeyID: id})
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req remoteSignRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig, err := remoteSign(key, &req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(remoteSignResponse{Signature: base64.RawURLEncoding.EncodeToString(sig)})
+	})
+}
+
+func remoteSign(key crypto.Signer, req *remoteSignRequest) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		msg, err := base64.RawURLEncoding.DecodeString(req.Message)
+		if err != nil {
+			return nil, err
+		}
+		return key.Sign(rand.Reader, msg, crypto.Hash(0))
+	}
+	var hash crypto.Hash
+	for _, h := range remoteSignHashes {
+		if h.String() == req.Hash {
+			hash = h
+		}
+	}
+	if hash == 0 {
+		return nil, fmt.Errorf("unsupported hash %q", req.Hash)
+	}
+	digest, err := base64.RawURLEncoding.DecodeString(req.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != hash.Size() {
+		return nil, errors.New("digest length does not match hash")
+	}
+	var opts crypto.SignerOpts = hash
+	if req.Pss {
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			return nil, errors.New("pss requires an RSA key")
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
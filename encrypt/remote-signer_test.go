@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newRemoteSignerStandIn starts a local signing service in place of the real
+// daemon, closed when the test ends.
+func newRemoteSignerStandIn(t *testing.T, keys map[string]crypto.Signer, token string) *httptest.Server {
+	srv := httptest.NewServer(RemoteSignerHandler(keys, token))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newRemoteSignerStandIn(t, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey}, "secret")
+	msg := []byte("hello")
+	digest := sha256.Sum256(msg)
+
+	rs, err := NewRemoteSigner(srv.URL, "rsa", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Client.GetClient().Timeout != defaultRemoteSignerTimeout {
+		t.Fatalf("client timeout %v", rs.Client.GetClient().Timeout)
+	}
+	sig, err := rs.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig) != nil {
+		t.Fatalf("PKCS#1 v1.5: %v", err)
+	}
+	pss := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	sig, err = rs.Sign(rand.Reader, digest[:], pss)
+	if err != nil || rsa.VerifyPSS(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig, pss) != nil {
+		t.Fatalf("PSS: %v", err)
+	}
+
+	es, err := NewRemoteSigner(srv.URL, "ec", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = es.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || !ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig) {
+		t.Fatalf("ECDSA: %v", err)
+	}
+	if _, err := es.Sign(rand.Reader, digest[:16], crypto.SHA256); err == nil {
+		t.Fatal("short digest signed")
+	}
+
+	ed, err := NewRemoteSigner(srv.URL, "ed", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = ed.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil || !ed25519.Verify(edKey.Public().(ed25519.PublicKey), msg, sig) {
+		t.Fatalf("Ed25519: %v", err)
+	}
+
+	if _, err := NewRemoteSigner(srv.URL, "rsa", "wrong"); err == nil {
+		t.Fatal("wrong token accepted")
+	}
+	if _, err := NewRemoteSigner(srv.URL, "missing", "secret"); err == nil {
+		t.Fatal("unknown key id accepted")
+	}
+}
+
+func TestRemoteSignerTimeout(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	handler := RemoteSignerHandler(map[string]crypto.Signer{"ec": key}, "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			<-release
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s, err := NewRemoteSigner(srv.URL, "ec", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Client.SetTimeout(50 * time.Millisecond)
+	digest := sha256.Sum256([]byte("hello"))
+	if _, err := s.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Fatal("hung service did not time out")
+	}
+}
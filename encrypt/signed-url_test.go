@@ -0,0 +1,131 @@
+package encrypt
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ring := NewDerivedKeyring("seed")
+	ring.Now = func() time.Time { return now }
+	s := NewURLSigner(ring)
+	s.Now = func() time.Time { return now }
+	parse := func(raw string) *url.URL {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	signed, err := s.Sign("https://files.internal/exports/report 1.csv?format=csv&sig=stale", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(parse(signed), "198.51.100.7"); err != nil {
+		t.Fatalf("verify %s: %v", signed, err)
+	}
+	if strings.Count(signed, "sig=") != 1 || !strings.Contains(signed, "kid=signed-url.v1") {
+		t.Fatalf("signed %s", signed)
+	}
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		want error
+	}{
+		{"other path", strings.Replace(signed, "report%201", "report%202", 1), ErrURLSignature},
+		{"changed parameter", strings.Replace(signed, "format=csv", "format=xlsx", 1), ErrURLSignature},
+		{"added parameter", signed + "&admin=1", ErrURLSignature},
+		{"extended expiry", strings.Replace(signed, "expires=1700003600", "expires=1800000000", 1), ErrURLSignature},
+		{"truncated signature", signed[:len(signed)-4], ErrURLSignature},
+		{"second signature", signed + "&sig=x", ErrURLSignature},
+		{"unsigned", "https://files.internal/exports/report%201.csv", ErrURLSignature},
+		{"unknown key", strings.Replace(signed, "kid=signed-url.v1", "kid=signed-url.v7", 1), ErrURLSignature},
+	} {
+		if err := s.Verify(parse(tc.url), ""); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	now = now.Add(time.Hour)
+	if err := s.Verify(parse(signed), ""); !errors.Is(err, ErrURLExpired) {
+		t.Fatalf("at expiry: %v", err)
+	}
+}
+
+func TestURLSignerBinding(t *testing.T) {
+	ring := NewDerivedKeyring("seed")
+	s := NewURLSigner(ring)
+	signed, err := s.Sign("https://files.internal/ca.pem", time.Minute, "203.0.113.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(signed, "203.0.113.9") {
+		t.Fatalf("client address in the URL: %s", signed)
+	}
+	u, _ := url.Parse(signed)
+	if err := s.Verify(u, "203.0.113.9"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"203.0.113.10", ""} {
+		if err := s.Verify(u, ip); !errors.Is(err, ErrURLSignature) {
+			t.Errorf("from %q: %v", ip, err)
+		}
+	}
+	// dropping the binding does not unbind the link
+	unbound, _ := url.Parse(strings.Replace(signed, "&bind=ip", "", 1))
+	if err := s.Verify(unbound, "203.0.113.10"); !errors.Is(err, ErrURLSignature) {
+		t.Fatalf("binding removed: %v", err)
+	}
+
+	// a key of another purpose from the same ring is refused
+	other := &URLSigner{Keyring: ring, Purpose: PurposeCookie}
+	if err := other.Verify(u, "203.0.113.9"); !errors.Is(err, ErrURLSignature) {
+		t.Fatalf("other purpose: %v", err)
+	}
+
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		remote string
+		status int
+	}{
+		{"203.0.113.9:51000", http.StatusOK},
+		{"203.0.113.10:51000", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, signed, nil)
+		req.RemoteAddr = tc.remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: %d, want %d", tc.remote, rec.Code, tc.status)
+		}
+	}
+}
+
+func TestURLSignerRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ring := NewDerivedKeyring("seed-1")
+	ring.Now = func() time.Time { return now }
+	s := NewURLSigner(ring)
+	s.Now = ring.Now
+	old, err := s.Sign("https://files.internal/a", 24*time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Rotate("seed-2", time.Hour)
+	u, _ := url.Parse(old)
+	if err := s.Verify(u, ""); err != nil {
+		t.Fatalf("old link in grace: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if err := s.Verify(u, ""); !errors.Is(err, ErrURLSignature) {
+		t.Fatalf("old link after grace: %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package encrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Just enough of Structured Field Values for HTTP to read and write the
+// dictionaries used by HTTP Message Signatures and Content-Digest.
+// https://www.rfc-editor.org/rfc/rfc8941
+
+var errSFSyntax = errors.New("structured field: syntax error")
+
+// sfToken is a bare token, kept apart from string so it serializes unquoted.
+type sfToken string
+
+// sfItem is a bare item or an inner list, with its parameters.
+// Value is int64, float64, string, sfToken, []byte, bool or []sfItem.
+type sfItem struct {
+	Value  interface{}
+	Params []sfParam
+}
+
+type sfParam struct {
+	Key   string
+	Value interface{}
+}
+
+type sfMember struct {
+	Key  string
+	Item sfItem
+}
+
+// sfDictionary keeps the members in order, later duplicates replace earlier ones.
+type sfDictionary []sfMember
+
+func (d sfDictionary) get(key string) (sfItem, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Item, true
+		}
+	}
+	return sfItem{}, false
+}
+
+func (it sfItem) param(key string) (interface{}, bool) {
+	for _, p := range it.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseSFDictionary parses a Dictionary field value.
+// Multiple field lines must be joined with "," first.
+func parseSFDictionary(s string) (sfDictionary, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+	var dict sfDictionary
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var item sfItem
+		if p.peek() == '=' {
+			p.pos++
+			if item, err = p.parseItemOrInnerList(); err != nil {
+				return nil, err
+			}
+		} else {
+			item.Value = true
+			if item.Params, err = p.parseParams(); err != nil {
+				return nil, err
+			}
+		}
+		dict = dict.set(key, item)
+		p.skipOWS()
+		if p.eof() {
+			return dict, nil
+		}
+		if p.peek() != ',' {
+			return nil, errSFSyntax
+		}
+		p.pos++
+		p.skipOWS()
+		if p.eof() {
+			return nil, errSFSyntax
+		}
+	}
+	return dict, nil
+}
+
+func (d sfDictionary) set(key string, item sfItem) sfDictionary {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Item = item
+			return d
+		}
+	}
+	return append(d, sfMember{Key: key, Item: item})
+}
+
+// parseSFItem parses a single Item field value.
+func parseSFItem(s string) (sfItem, error) {
+	p := &sfParser{s: s}
+	p.skipSP()
+	item, err := p.parseItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	p.skipSP()
+	if !p.eof() {
+		return sfItem{}, errSFSyntax
+	}
+	return item, nil
+}
+
+func (p *sfParser) parseItemOrInnerList() (sfItem, error) {
+	if p.peek() != '(' {
+		return p.parseItem()
+	}
+	p.pos++
+	list := []sfItem{}
+	for {
+		p.skipSP()
+		if p.eof() {
+			return sfItem{}, errSFSyntax
+		}
+		if p.peek() == ')' {
+			p.pos++
+			params, err := p.parseParams()
+			return sfItem{Value: list, Params: params}, err
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return sfItem{}, err
+		}
+		list = append(list, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return sfItem{}, errSFSyntax
+		}
+	}
+}
+
+func (p *sfParser) parseItem() (sfItem, error) {
+	v, err := p.parseBareItem()
+	if err != nil {
+		return sfItem{}, err
+	}
+	params, err := p.parseParams()
+	return sfItem{Value: v, Params: params}, err
+}
+
+func (p *sfParser) parseParams() ([]sfParam, error) {
+	var params []sfParam
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var v interface{} = true
+		if p.peek() == '=' {
+			p.pos++
+			if v, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		replaced := false
+		for i := range params {
+			if params[i].Key == key {
+				params[i].Value, replaced = v, true
+			}
+		}
+		if !replaced {
+			params = append(params, sfParam{Key: key, Value: v})
+		}
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", errSFSyntax
+	}
+	for !p.eof() {
+		c := p.s[p.pos]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("_-.*", rune(c)) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *sfParser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		p.pos++
+		switch p.peek() {
+		case '1':
+			p.pos++
+			return true, nil
+		case '0':
+			p.pos++
+			return false, nil
+		}
+		return nil, errSFSyntax
+	case c == '*' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+		start := p.pos
+		for !p.eof() {
+			c := p.s[p.pos]
+			if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),;<=>?@[\]{}`, rune(c)) {
+				break
+			}
+			p.pos++
+		}
+		return sfToken(p.s[start:p.pos]), nil
+	}
+	return nil, errSFSyntax
+}
+
+func (p *sfParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	decimal := false
+	for !p.eof() {
+		c := p.s[p.pos]
+		if c == '.' && !decimal {
+			decimal = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+		p.pos++
+	}
+	num := p.s[start:p.pos]
+	if decimal {
+		frac := num[strings.IndexByte(num, '.')+1:]
+		if len(frac) == 0 || len(frac) > 3 || len(num)-len(frac)-1 > 13 {
+			return nil, errSFSyntax
+		}
+		return strconv.ParseFloat(num, 64)
+	}
+	if len(strings.TrimPrefix(num, "-")) > 15 {
+		return nil, errSFSyntax
+	}
+	return strconv.ParseInt(num, 10, 64)
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.eof() {
+				return "", errSFSyntax
+			}
+			c = p.s[p.pos]
+			p.pos++
+			if c != '"' && c != '\\' {
+				return "", errSFSyntax
+			}
+			b.WriteByte(c)
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", errSFSyntax
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errSFSyntax
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.pos++
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, errSFSyntax
+	}
+	enc := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		// padding is optional when parsing
+		if b, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(enc, "=")); err != nil {
+			return nil, errSFSyntax
+		}
+	}
+	return b, nil
+}
+
+// serialize writes the item or inner list in canonical form.
+func (it sfItem) serialize() (string, error) {
+	var b strings.Builder
+	if list, ok := it.Value.([]sfItem); ok {
+		b.WriteByte('(')
+		for i, li := range list {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			s, err := li.serialize()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		}
+		b.WriteByte(')')
+	} else if err := sfSerializeBare(&b, it.Value); err != nil {
+		return "", err
+	}
+	for _, p := range it.Params {
+		b.WriteByte(';')
+		b.WriteString(p.Key)
+		if v, ok := p.Value.(bool); ok && v {
+			continue
+		}
+		b.WriteByte('=')
+		if err := sfSerializeBare(&b, p.Value); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func sfSerializeBare(b *strings.Builder, v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case int:
+		b.WriteString(strconv.Itoa(v))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("%w: non-printable character in string", errSFSyntax)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case sfToken:
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("%w: cannot serialize %T", errSFSyntax, v)
+	}
+	return nil
+}
+
+// serialize writes the dictionary in canonical form.
+func (d sfDictionary) serialize() (string, error) {
+	parts := make([]string, len(d))
+	for i, m := range d {
+		if v, ok := m.Item.Value.(bool); ok && v {
+			s, err := sfItem{Value: sfToken(""), Params: m.Item.Params}.serialize()
+			if err != nil {
+				return "", err
+			}
+			parts[i] = m.Key + s
+			continue
+		}
+		s, err := m.Item.serialize()
+		if err != nil {
+			return "", err
+		}
+		parts[i] = m.Key + "=" + s
+	}
+	return strings.Join(parts, ", "), nil
+}
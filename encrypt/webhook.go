@@ -0,0 +1,228 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWebhookSignature is returned when a delivery is unsigned or the signature does not match.
+	ErrWebhookSignature = errors.New("webhook: signature verification failed")
+	// ErrWebhookTimestamp is returned when a signed timestamp is outside the tolerance.
+	ErrWebhookTimestamp = errors.New("webhook: timestamp outside tolerance")
+	// ErrWebhookSecret is returned for a verifier without a secret, which
+	// would otherwise accept anyone who knows to sign with the empty key.
+	ErrWebhookSecret = errors.New("webhook: empty secret")
+)
+
+// defaultWebhookTolerance matches what Stripe and Slack recommend.
+const defaultWebhookTolerance = 5 * time.Minute
+
+// webhookMaxBody caps the body read by WebhookMiddleware, GitHub sends up to 25 MB.
+const webhookMaxBody = 25 << 20
+
+// WebhookVerifier checks one delivery given its headers and raw body.
+type WebhookVerifier interface {
+	Verify(header http.Header, body []byte) error
+}
+
+// hexHmacEqual compares a hex encoded signature to the HMAC-SHA256 of data in constant time.
+func hexHmacEqual(sig string, data []byte, secret string) bool {
+	return HmacVerifyBytes(HmacSHA256, []byte(secret), data, sig, EncodingHex) == nil
+}
+
+// checkWebhookTimestamp parses unix seconds and checks them against tolerance.
+func checkWebhookTimestamp(ts string, tolerance time.Duration, now func() time.Time) error {
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp", ErrWebhookSignature)
+	}
+	if tolerance == 0 {
+		tolerance = defaultWebhookTolerance
+	}
+	t := time.Now()
+	if now != nil {
+		t = now()
+	}
+	if d := t.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return ErrWebhookTimestamp
+	}
+	return nil
+}
+
+// GitHubWebhook checks X-Hub-Signature-256. GitHub signs no timestamp, so
+// replays can only be caught by remembering X-GitHub-Delivery.
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+type GitHubWebhook struct {
+	Secret string
+}
+
+func NewGitHubWebhook(secret string) (GitHubWebhook, error) {
+	if secret == "" {
+		return GitHubWebhook{}, ErrWebhookSecret
+	}
+	return GitHubWebhook{Secret: secret}, nil
+}
+
+func (v GitHubWebhook) Verify(header http.Header, body []byte) error {
+	if v.Secret == "" {
+		return ErrWebhookSecret
+	}
+	sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok || !hexHmacEqual(sig, body, v.Secret) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// StripeWebhook checks Stripe-Signature, "t=<unix>,v1=<hex>[,v1=...]".
+// https://stripe.com/docs/webhooks#verify-manually
+type StripeWebhook struct {
+	Secret    string
+	Tolerance time.Duration // 5 minutes by default
+	Now       func() time.Time
+}
+
+func NewStripeWebhook(secret string) (StripeWebhook, error) {
+	if secret == "" {
+		return StripeWebhook{}, ErrWebhookSecret
+	}
+	return StripeWebhook{Secret: secret}, nil
+}
+
+func (v StripeWebhook) Verify(header http.Header, body []byte) error {
+	if v.Secret == "" {
+		return ErrWebhookSecret
+	}
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		k, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = val
+		case "v1":
+			sigs = append(sigs, val)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return ErrWebhookSignature
+	}
+	if err := checkWebhookTimestamp(ts, v.Tolerance, v.Now); err != nil {
+		return err
+	}
+	payload := append([]byte(ts+"."), body...)
+	// several v1 signatures are sent while a secret is being rolled
+	for _, sig := range sigs {
+		if hexHmacEqual(sig, payload, v.Secret) {
+			return nil
+		}
+	}
+	return ErrWebhookSignature
+}
+
+// SlackWebhook checks X-Slack-Signature over the v0 basestring.
+// https://api.slack.com/authentication/verifying-requests-from-slack
+type SlackWebhook struct {
+	SigningSecret string
+	Tolerance     time.Duration // 5 minutes by default
+	Now           func() time.Time
+}
+
+func NewSlackWebhook(signingSecret string) (SlackWebhook, error) {
+	if signingSecret == "" {
+		return SlackWebhook{}, ErrWebhookSecret
+	}
+	return SlackWebhook{SigningSecret: signingSecret}, nil
+}
+
+func (v SlackWebhook) Verify(header http.Header, body []byte) error {
+	if v.SigningSecret == "" {
+		return ErrWebhookSecret
+	}
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sig, ok := strings.CutPrefix(header.Get("X-Slack-Signature"), "v0=")
+	if ts == "" || !ok {
+		return ErrWebhookSignature
+	}
+	if err := checkWebhookTimestamp(ts, v.Tolerance, v.Now); err != nil {
+		return err
+	}
+	if !hexHmacEqual(sig, append([]byte("v0:"+ts+":"), body...), v.SigningSecret) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// GitLabWebhook compares the plain secret token GitLab sends in X-Gitlab-Token.
+// There is neither a signature nor a timestamp, so only use it over TLS.
+// https://docs.gitlab.com/ee/user/project/integrations/webhooks.html#validate-payloads-by-using-a-secret-token
+type GitLabWebhook struct {
+	Token string
+}
+
+func NewGitLabWebhook(token string) (GitLabWebhook, error) {
+	if token == "" {
+		return GitLabWebhook{}, ErrWebhookSecret
+	}
+	return GitLabWebhook{Token: token}, nil
+}
+
+func (v GitLabWebhook) Verify(header http.Header, body []byte) error {
+	if v.Token == "" {
+		return ErrWebhookSecret
+	}
+	token := header.Get("X-Gitlab-Token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(v.Token)) != 1 {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+// WebhookMiddleware verifies deliveries with the verifier registered for the
+// request path, keys are exact paths or prefixes ending in "/" and the longest
+// match wins. Paths without a verifier get 404, failed checks 401. The body
+// is left readable for next.
+func WebhookMiddleware(routes map[string]WebhookVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifier := webhookRoute(routes, r.URL.Path)
+		if verifier == nil {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := verifier.Verify(r.Header, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func webhookRoute(routes map[string]WebhookVerifier, path string) WebhookVerifier {
+	if v, ok := routes[path]; ok {
+		return v
+	}
+	var best string
+	for prefix := range routes {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return routes[best]
+}
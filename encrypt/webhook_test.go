@@ -0,0 +1,121 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hexHmac(secret string, data string) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(m, data)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func TestWebhookEmptySecret(t *testing.T) {
+	if _, err := NewGitHubWebhook(""); !errors.Is(err, ErrWebhookSecret) {
+		t.Fatalf("GitHub: %v", err)
+	}
+	if _, err := NewStripeWebhook(""); !errors.Is(err, ErrWebhookSecret) {
+		t.Fatalf("Stripe: %v", err)
+	}
+	if _, err := NewSlackWebhook(""); !errors.Is(err, ErrWebhookSecret) {
+		t.Fatalf("Slack: %v", err)
+	}
+	if _, err := NewGitLabWebhook(""); !errors.Is(err, ErrWebhookSecret) {
+		t.Fatalf("GitLab: %v", err)
+	}
+
+	// a zero verifier refuses deliveries signed with the empty key
+	body := []byte(`{}`)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	for name, tc := range map[string]struct {
+		v      WebhookVerifier
+		header http.Header
+	}{
+		"GitHub": {GitHubWebhook{}, http.Header{"X-Hub-Signature-256": {"sha256=" + hexHmac("", "{}")}}},
+		"Stripe": {StripeWebhook{}, http.Header{"Stripe-Signature": {"t=" + ts + ",v1=" + hexHmac("", ts+".{}")}}},
+		"Slack":  {SlackWebhook{}, http.Header{"X-Slack-Request-Timestamp": {ts}, "X-Slack-Signature": {"v0=" + hexHmac("", "v0:"+ts+":{}")}}},
+	} {
+		if err := tc.v.Verify(tc.header, body); !errors.Is(err, ErrWebhookSecret) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestWebhookVerify(t *testing.T) {
+	body := `{"id":"evt_1"}`
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	clock := func() time.Time { return now }
+
+	github, _ := NewGitHubWebhook("gh-secret")
+	stripe, _ := NewStripeWebhook("whsec_new")
+	stripe.Now = clock
+	slack, _ := NewSlackWebhook("slack-secret")
+	slack.Now = clock
+	gitlab, _ := NewGitLabWebhook("gl-token")
+
+	for _, tc := range []struct {
+		name   string
+		v      WebhookVerifier
+		header http.Header
+		body   string
+		want   error
+	}{
+		{"GitHub", github, http.Header{"X-Hub-Signature-256": {"sha256=" + hexHmac("gh-secret", body)}}, body, nil},
+		{"GitHub tampered", github, http.Header{"X-Hub-Signature-256": {"sha256=" + hexHmac("gh-secret", body)}}, body + " ", ErrWebhookSignature},
+		{"GitHub sha1 only", github, http.Header{"X-Hub-Signature": {"sha1=00"}}, body, ErrWebhookSignature},
+		// the old secret is still sent while rolling
+		{"Stripe rolled", stripe, http.Header{"Stripe-Signature": {"t=" + ts + ",v1=" + hexHmac("whsec_old", ts+"."+body) + ",v1=" + hexHmac("whsec_new", ts+"."+body)}}, body, nil},
+		{"Stripe old secret", stripe, http.Header{"Stripe-Signature": {"t=" + ts + ",v1=" + hexHmac("whsec_old", ts+"."+body)}}, body, ErrWebhookSignature},
+		{"Stripe stale", stripe, http.Header{"Stripe-Signature": {"t=" + strconv.FormatInt(now.Unix()-600, 10) + ",v1=00"}}, body, ErrWebhookTimestamp},
+		{"Slack", slack, http.Header{"X-Slack-Request-Timestamp": {ts}, "X-Slack-Signature": {"v0=" + hexHmac("slack-secret", "v0:"+ts+":"+body)}}, body, nil},
+		{"Slack other timestamp", slack, http.Header{"X-Slack-Request-Timestamp": {strconv.FormatInt(now.Unix()+1, 10)}, "X-Slack-Signature": {"v0=" + hexHmac("slack-secret", "v0:"+ts+":"+body)}}, body, ErrWebhookSignature},
+		{"GitLab", gitlab, http.Header{"X-Gitlab-Token": {"gl-token"}}, body, nil},
+		{"GitLab wrong token", gitlab, http.Header{"X-Gitlab-Token": {"gl-tok"}}, body, ErrWebhookSignature},
+	} {
+		if err := tc.v.Verify(tc.header, []byte(tc.body)); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestWebhookMiddleware(t *testing.T) {
+	github, _ := NewGitHubWebhook("gh-secret")
+	gitlab, _ := NewGitLabWebhook("gl-token")
+	h := WebhookMiddleware(map[string]WebhookVerifier{"/hooks/": gitlab, "/hooks/github": github}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	for _, tc := range []struct {
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"/hooks/github", "X-Hub-Signature-256", "sha256=" + hexHmac("gh-secret", "ping"), http.StatusOK},
+		{"/hooks/github", "X-Gitlab-Token", "gl-token", http.StatusUnauthorized},
+		{"/hooks/gitlab", "X-Gitlab-Token", "gl-token", http.StatusOK},
+		{"/other", "X-Gitlab-Token", "gl-token", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader("ping"))
+		req.Header.Set(tc.header, tc.value)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: %d, want %d", tc.path, tc.header, rec.Code, tc.status)
+		}
+		if tc.status == http.StatusOK && rec.Body.String() != "ping" {
+			t.Errorf("%s: body %q", tc.path, rec.Body)
+		}
+	}
+}
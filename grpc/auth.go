@@ -2,12 +2,19 @@ package grpc
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
+	"mygolibs/encrypt"
+	"net"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 type Token struct {
@@ -43,17 +50,149 @@ func InjectGrpcCustomAuth(secret string, host string, port int, usingTls bool, p
 	return conn, grpcErr
 }
 
+// VerifyGrpcCustomAuth checks the app_secret sent by InjectGrpcCustomAuth.
+// password is preferably a hash from encrypt.HashPassword, so no plaintext
+// secret has to sit in the server config; plaintext still works and is
+// compared in constant time. Hash checks are cached, see verifyHashedSecret.
 func VerifyGrpcCustomAuth(ctx context.Context, password string) bool {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return false
 	}
 	var AppSecret string
-	if val, ok := md["app_secret"]; ok {
+	if val, ok := md["app_secret"]; ok && len(val) > 0 {
 		AppSecret = val[0]
 	}
-	if AppSecret != password {
+	if AppSecret == "" {
 		return false
 	}
+	if encrypt.IsPasswordHash(password) {
+		return verifyHashedSecret(ctx, AppSecret, password)
+	}
+	return subtle.ConstantTimeCompare([]byte(AppSecret), []byte(password)) == 1
+}
+
+const (
+	secretCacheTTL  = 10 * time.Minute
+	secretCacheSize = 1024
+	// each argon2id check takes 64 MiB with the default parameters
+	maxSecretChecks = 2
+	// a wrong secret is refused without hashing for secretFailureTTL, and a
+	// peer that sent one waits from secretBackoff up to maxSecretBackoff,
+	// doubling with every failure, before its next check
+	secretFailureTTL = time.Minute
+	secretBackoff    = 100 * time.Millisecond
+	maxSecretBackoff = 30 * time.Second
+)
+
+type secretFailure struct {
+	count int
+	until time.Time
+}
+
+var (
+	secretCacheMu  sync.Mutex
+	secretCache    = map[[32]byte]time.Time{} // sha256 of hash and secret -> expiry
+	failedSecrets  = map[[32]byte]time.Time{} // same key, for wrong secrets
+	secretFailures = map[string]*secretFailure{}
+	secretChecks   = make(chan struct{}, maxSecretChecks)
+	secretNow      = time.Now
+)
+
+// verifyHashedSecret checks secret against encoded once per secretCacheTTL,
+// instead of running the slow hash on every RPC. At most maxSecretChecks
+// hashes run at once, and wrong secrets are remembered for secretFailureTTL
+// while the peer that sent them backs off, so unauthenticated clients can
+// neither exhaust memory nor keep the slots busy.
+func verifyHashedSecret(ctx context.Context, secret string, encoded string) bool {
+	key := sha256.Sum256([]byte(encoded + "\x00" + secret))
+	addr := peerHost(ctx)
+	now := secretNow()
+	secretCacheMu.Lock()
+	expires, ok := secretCache[key]
+	failed, isFailed := failedSecrets[key]
+	backoff, backingOff := secretFailures[addr]
+	secretCacheMu.Unlock()
+	switch {
+	case ok && now.Before(expires):
+		return true
+	case isFailed && now.Before(failed):
+		return false
+	case backingOff && now.Before(backoff.until):
+		return false
+	}
+
+	select {
+	case secretChecks <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	err := encrypt.VerifyPassword(secret, encoded)
+	<-secretChecks
+
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+	now = secretNow()
+	if err != nil {
+		pruneSecretCache(failedSecrets, now)
+		failedSecrets[key] = now.Add(secretFailureTTL)
+		f := secretFailures[addr]
+		if f == nil {
+			if len(secretFailures) >= secretCacheSize {
+				for a, f := range secretFailures {
+					if now.After(f.until.Add(maxSecretBackoff)) {
+						delete(secretFailures, a)
+					}
+				}
+			}
+			if len(secretFailures) >= secretCacheSize {
+				secretFailures = map[string]*secretFailure{}
+			}
+			f = &secretFailure{}
+			secretFailures[addr] = f
+		}
+		f.count++
+		wait := maxSecretBackoff
+		if f.count <= 16 && secretBackoff<<(f.count-1) < maxSecretBackoff {
+			wait = secretBackoff << (f.count - 1)
+		}
+		f.until = now.Add(wait)
+		return false
+	}
+	delete(secretFailures, addr)
+	pruneSecretCache(secretCache, now)
+	secretCache[key] = now.Add(secretCacheTTL)
 	return true
 }
+
+// pruneSecretCache makes room for one more entry, dropping expired ones
+// first and everything if that is not enough. secretCacheMu must be held.
+func pruneSecretCache(cache map[[32]byte]time.Time, now time.Time) {
+	if len(cache) < secretCacheSize {
+		return
+	}
+	for k, exp := range cache {
+		if now.After(exp) {
+			delete(cache, k)
+		}
+	}
+	if len(cache) >= secretCacheSize {
+		for k := range cache {
+			delete(cache, k)
+		}
+	}
+}
+
+// peerHost is the address of the calling peer without its port, the
+// unit failures are counted by.
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,162 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"testing"
+	"time"
+
+	"mygolibs/encrypt"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// cheap parameters, the check only has to be real, not slow
+var testHasher = &encrypt.PasswordHasher{
+	Algorithm: encrypt.PasswordArgon2id,
+	Argon2id:  encrypt.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1},
+}
+
+func resetSecretCache(t *testing.T, now time.Time) *time.Time {
+	secretCacheMu.Lock()
+	secretCache = map[[32]byte]time.Time{}
+	failedSecrets = map[[32]byte]time.Time{}
+	secretFailures = map[string]*secretFailure{}
+	secretCacheMu.Unlock()
+	clock := &now
+	secretNow = func() time.Time { return *clock }
+	t.Cleanup(func() { secretNow = time.Now })
+	return clock
+}
+
+func peerContext(ip string, secret string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}})
+	return metadata.NewIncomingContext(ctx, metadata.Pairs("app_secret", secret))
+}
+
+// takeSecretChecks takes every hashing slot, so any call that gets past the
+// caches blocks until the returned func releases them.
+func takeSecretChecks() (release func()) {
+	for i := 0; i < maxSecretChecks; i++ {
+		secretChecks <- struct{}{}
+	}
+	return func() {
+		for i := 0; i < maxSecretChecks; i++ {
+			<-secretChecks
+		}
+	}
+}
+
+// refusedUnhashed reports whether the check failed without waiting for a
+// hashing slot.
+func refusedUnhashed(ctx context.Context, secret string, encoded string) bool {
+	defer takeSecretChecks()()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return !verifyHashedSecret(ctx, secret, encoded) && ctx.Err() == nil
+}
+
+func TestVerifyGrpcCustomAuth(t *testing.T) {
+	resetSecretCache(t, time.Unix(1700000000, 0))
+	hash, err := testHasher.Hash("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		password string
+		want     bool
+	}{
+		{"hash", peerContext("192.0.2.1", "app-secret"), hash, true},
+		{"plaintext", peerContext("192.0.2.1", "app-secret"), "app-secret", true},
+		{"wrong plaintext", peerContext("192.0.2.1", "app-secrets"), "app-secret", false},
+		{"empty secret", peerContext("192.0.2.1", ""), "", false},
+		{"no metadata", context.Background(), "app-secret", false},
+	} {
+		if got := VerifyGrpcCustomAuth(tc.ctx, tc.password); got != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestVerifyHashedSecretCache(t *testing.T) {
+	clock := resetSecretCache(t, time.Unix(1700000000, 0))
+	hash, err := testHasher.Hash("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyHashedSecret(peerContext("192.0.2.1", "app-secret"), "app-secret", hash) {
+		t.Fatal("right secret refused")
+	}
+
+	// with every slot taken only cached answers come back
+	release := takeSecretChecks()
+	if !verifyHashedSecret(context.Background(), "app-secret", hash) {
+		release()
+		t.Fatal("cached secret refused")
+	}
+	*clock = clock.Add(secretCacheTTL + time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ok := verifyHashedSecret(ctx, "app-secret", hash)
+	release()
+	if ok || ctx.Err() == nil {
+		t.Fatal("expired entry used")
+	}
+	if !verifyHashedSecret(context.Background(), "app-secret", hash) {
+		t.Fatal("right secret refused once a slot was free")
+	}
+}
+
+func TestVerifyHashedSecretFailures(t *testing.T) {
+	clock := resetSecretCache(t, time.Unix(1700000000, 0))
+	hash, err := testHasher.Hash("app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attacker, other := peerContext("192.0.2.66", ""), peerContext("192.0.2.1", "")
+	if verifyHashedSecret(attacker, "guess-1", hash) {
+		t.Fatal("wrong secret accepted")
+	}
+	// the same wrong secret, and anything from the failing peer, is refused
+	// without hashing
+	if !refusedUnhashed(other, "guess-1", hash) {
+		t.Fatal("known wrong secret hashed again")
+	}
+	if !refusedUnhashed(attacker, "guess-2", hash) {
+		t.Fatal("peer not backing off")
+	}
+	if !verifyHashedSecret(other, "app-secret", hash) {
+		t.Fatal("other peer locked out")
+	}
+
+	// the backoff doubles with every failure and a success ends it
+	*clock = clock.Add(secretBackoff)
+	if verifyHashedSecret(attacker, "guess-2", hash) {
+		t.Fatal("wrong secret accepted")
+	}
+	*clock = clock.Add(secretBackoff)
+	if !refusedUnhashed(attacker, "guess-3", hash) {
+		t.Fatal("peer not backing off after a second failure")
+	}
+	*clock = clock.Add(secretBackoff)
+	if verifyHashedSecret(attacker, "guess-3", hash) {
+		t.Fatal("wrong secret accepted")
+	}
+	*clock = clock.Add(4 * secretBackoff)
+	secretCacheMu.Lock()
+	delete(secretCache, sha256.Sum256([]byte(hash+"\x00app-secret")))
+	secretCacheMu.Unlock()
+	if !verifyHashedSecret(attacker, "app-secret", hash) {
+		t.Fatal("backoff did not end")
+	}
+	secretCacheMu.Lock()
+	_, failing := secretFailures["192.0.2.66"]
+	secretCacheMu.Unlock()
+	if failing {
+		t.Fatal("success did not reset the backoff")
+	}
+}
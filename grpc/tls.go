@@ -0,0 +1,24 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// InjectGrpcTLSConfig dials with conf, such as pki.ClientTLSConfig for mTLS,
+// and sends secret as app_secret when it is not empty.
+func InjectGrpcTLSConfig(secret string, host string, port int, conf *tls.Config) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(conf))}
+	if secret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&Token{AppSecret: secret}))
+	}
+	return grpc.Dial(fmt.Sprintf("%s:%v", host, port), opts...)
+}
+
+// GrpcServerTLS is a server option serving with conf, such as pki.ServerTLSConfig.
+func GrpcServerTLS(conf *tls.Config) grpc.ServerOption {
+	return grpc.Creds(credentials.NewTLS(conf))
+}
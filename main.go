@@ -1,21 +1,42 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 
+	"mygolibs/applications/protocols/acme"
 	control "mygolibs/control"
+	"mygolibs/encrypt"
+	"mygolibs/pki"
 )
 
 // A simplest example of parsing command args
 var (
-	Port int
+	Port         int
+	HashSecret   bool
+	InspectCert  string
+	InspectHost  string
+	InspectRoots string
+	ExportFile   string
+	AgeRecipient string
 )
 
 // Parse finished, now you can use `Port` directly.
 func init() {
 	flag.IntVar(&Port, "port", 8045, "Running port")
+	flag.BoolVar(&HashSecret, "hash-secret", false, "Read a client secret from stdin, print its argon2id hash for the config file and exit")
+	flag.StringVar(&InspectCert, "inspect-cert", "", "Inspect and verify a PEM chain file or the chain served at host[:port], then exit")
+	flag.StringVar(&InspectHost, "inspect-hostname", "", "Hostname the inspected leaf must be valid for")
+	flag.StringVar(&InspectRoots, "inspect-roots", "", "PEM file of roots to verify against instead of the system roots")
+	flag.StringVar(&ExportFile, "export-metrics", "", "Write a metrics snapshot encrypted to -age-recipient to this file and exit")
+	flag.StringVar(&AgeRecipient, "age-recipient", "", "age public key (age1...) or recipients file to encrypt exports to")
 	flag.Parse()
 }
 
@@ -35,6 +56,101 @@ func controlExec(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(w, res)
 }
 
+func encryptHmac(w http.ResponseWriter, req *http.Request) {
+	keyID, ok := encrypt.HMACRequestKeyFromContext(req.Context())
+	if !ok {
+		fmt.Fprint(w, "request is not signed, set hmac_seed in conf.yml\n")
+		return
+	}
+	fmt.Fprintf(w, "signed by %s\n", keyID)
+}
+
+// hashSecret hashes the first line of stdin, so the secret stays out of ps
+// and shell history.
+func hashSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return "", errors.New("no secret on stdin")
+	}
+	return encrypt.HashPassword(secret)
+}
+
+// inspectCert prints the report of -inspect-cert and returns the exit code,
+// 1 when the chain does not verify.
+func inspectCert() int {
+	opts := pki.InspectOptions{Hostname: InspectHost}
+	if InspectRoots != "" {
+		data, err := os.ReadFile(InspectRoots)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		roots, err := pki.ParseCertificatesPEM(data)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		opts.Roots = pki.CertPool(roots...)
+	}
+	var report *pki.ChainReport
+	if data, err := os.ReadFile(InspectCert); err == nil {
+		report, err = pki.InspectChainPEM(data, opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else if !os.IsNotExist(err) {
+		// a file we can't read, not an address
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	} else {
+		addr := InspectCert
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "443")
+		}
+		report, err = pki.InspectRemote(addr, opts, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	fmt.Print(report)
+	if !report.Verified {
+		return 1
+	}
+	return 0
+}
+
+// exportMetrics writes -export-metrics, -age-recipient is a key or a file
+// of keys.
+func exportMetrics() error {
+	keys := AgeRecipient
+	if data, err := os.ReadFile(AgeRecipient); err == nil {
+		keys = string(data)
+	}
+	recipients, err := encrypt.ParseAgeRecipients(keys)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ExportFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = control.ExportMetrics(f, recipients...)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// no half written export left behind
+		os.Remove(ExportFile)
+	}
+	return err
+}
+
 func headers(w http.ResponseWriter, req *http.Request) {
 	for name, headers := range req.Header {
 		for _, h := range headers {
@@ -44,21 +160,59 @@ func headers(w http.ResponseWriter, req *http.Request) {
 }
 
 func main() {
+	if HashSecret {
+		hash, err := hashSecret()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(hash)
+		return
+	}
+	if InspectCert != "" {
+		os.Exit(inspectCert())
+	}
+	if ExportFile != "" {
+		if err := exportMetrics(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// examples
 	http.HandleFunc("/hello", hello)
 	http.HandleFunc("/headers", headers)
 
 	// module tester
-	http.HandleFunc("/test/control/exec", controlExec)
-	http.HandleFunc("/test/control/metric/static", placeholder)
-	http.HandleFunc("/test/control/metric/dynamic", placeholder)
+	test := http.NewServeMux()
+	test.HandleFunc("/test/control/exec", controlExec)
+	test.HandleFunc("/test/control/metric/static", placeholder)
+	test.HandleFunc("/test/control/metric/dynamic", placeholder)
 
-	http.HandleFunc("/test/encrypt/hmac", placeholder)
+	test.HandleFunc("/test/encrypt/hmac", encryptHmac)
 
-	http.HandleFunc("/test/files/yaml", placeholder)
+	test.HandleFunc("/test/files/yaml", placeholder)
 
 	// TODO: how to test grpc???
-	// http.HandleFunc("/test/grpc/node", placeholder)
+	// test.HandleFunc("/test/grpc/node", placeholder)
+
+	// the seed comes from the config file, not the command line where ps
+	// and shell history would show it
+	conf := &acme.StellarConf{}
+	if err := acme.LoadConf("./conf.yml", conf); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var testHandler http.Handler = test
+	if conf.HMACSeed != "" {
+		testHandler = encrypt.NewHMACRequestVerifier(encrypt.SeedHMACKeys(conf.HMACSeed)).Middleware(test)
+
+		// GET ?addr=host:port or POST a PEM chain. GET dials any address, so
+		// only signed clients get it.
+		test.Handle("/test/pki/inspect", pki.InspectHandler(pki.InspectOptions{}))
+	}
+	http.Handle("/test/", testHandler)
 
 	// serve
 	http.ListenAndServe(fmt.Sprintf(":%d", Port), nil)
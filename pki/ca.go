@@ -0,0 +1,370 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A small local CA for bootstrapping mTLS between agents and the controller,
+// replacing the openssl scripts. Profiles follow RFC 5280,
+// https://tools.ietf.org/html/rfc5280
+
+// Key types for generated keys.
+const (
+	KeyECDSAP256 = "ecdsa-p256"
+	KeyECDSAP384 = "ecdsa-p384"
+	KeyRSA2048   = "rsa-2048"
+	KeyRSA3072   = "rsa-3072"
+	KeyRSA4096   = "rsa-4096"
+	KeyEd25519   = "ed25519"
+)
+
+const (
+	defaultRootValidity         = 10 * 365 * 24 * time.Hour
+	defaultIntermediateValidity = 5 * 365 * 24 * time.Hour
+	defaultLeafValidity         = 90 * 24 * time.Hour
+	defaultCRLValidity          = 7 * 24 * time.Hour
+	// backdate covers clock skew between the CA and the peers
+	backdate = 5 * time.Minute
+)
+
+var (
+	// ErrNotCA is returned when a certificate that is not a CA is asked to sign.
+	ErrNotCA = errors.New("pki: certificate is not a CA")
+	// ErrNoSubjectAltName is returned when a server certificate has no DNS name or IP.
+	ErrNoSubjectAltName = errors.New("pki: server certificate needs a DNS name or IP address")
+)
+
+// GenerateKey creates a private key of keyType, KeyECDSAP256 when empty.
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("pki: unsupported key type %q", keyType)
+}
+
+// CertRequest describes a certificate to issue.
+type CertRequest struct {
+	CommonName     string
+	Organization   []string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL // e.g. spiffe://cluster/agent/1
+	EmailAddresses []string
+	Validity       time.Duration // defaults depend on the profile
+	KeyType        string        // used when Key is nil, KeyECDSAP256 by default
+	Key            crypto.Signer // optional, a key is generated when nil
+	// ExtKeyUsage replaces the usages of the profile when set.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// MaxPathLen limits the CAs below an intermediate, 0 by default so it
+	// can only issue leaves. Ignored for leaves.
+	MaxPathLen int
+}
+
+// Certificate is a certificate with its private key and the CAs that
+// issued it, nearest first and up to the root. Chain is empty for a root.
+type Certificate struct {
+	Cert  *x509.Certificate
+	Key   crypto.Signer
+	Chain []*x509.Certificate
+}
+
+// CA issues certificates and CRLs. Revocations and the CRL number are kept
+// in memory; store each CRL and hand the last one to LoadCRL after a restart,
+// so revocations survive and CRL numbers keep increasing.
+type CA struct {
+	Certificate
+	// CRLDistributionPoints and OCSPServers are written into every issued certificate.
+	CRLDistributionPoints []string
+	OCSPServers           []string
+
+	mu        sync.Mutex
+	revoked   []x509.RevocationListEntry
+	crlNumber *big.Int // of the last CRL, nil before the first
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func requestKey(req CertRequest) (crypto.Signer, error) {
+	if req.Key != nil {
+		return req.Key, nil
+	}
+	return GenerateKey(req.KeyType)
+}
+
+// template fills the fields shared by every profile.
+func template(req CertRequest, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	if req.Validity > 0 {
+		validity = req.Validity
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   req.CommonName,
+			Organization: req.Organization,
+		},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(validity),
+		DNSNames:              req.DNSNames,
+		IPAddresses:           req.IPAddresses,
+		URIs:                  req.URIs,
+		EmailAddresses:        req.EmailAddresses,
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+// NewRootCA creates a self-signed root, valid 10 years by default.
+func NewRootCA(req CertRequest) (*CA, error) {
+	if req.CommonName == "" {
+		return nil, errors.New("pki: CA needs a common name")
+	}
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(req, defaultRootValidity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: Certificate{Cert: cert, Key: key}}, nil
+}
+
+// NewCA wraps an existing CA certificate and key, e.g. from LoadCertificateFiles.
+func NewCA(cert *Certificate) (*CA, error) {
+	if !cert.Cert.IsCA || cert.Cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, ErrNotCA
+	}
+	return &CA{Certificate: *cert}, nil
+}
+
+// Root returns the self-signed certificate at the top of the chain.
+func (ca *CA) Root() *x509.Certificate {
+	if len(ca.Chain) == 0 {
+		return ca.Cert
+	}
+	return ca.Chain[len(ca.Chain)-1]
+}
+
+// sign issues tmpl for key and returns it with the chain up to the root.
+// NotAfter is clamped to the CA's own, a certificate can't outlive its issuer.
+func (ca *CA) sign(tmpl *x509.Certificate, key crypto.Signer) (*Certificate, error) {
+	if !time.Now().Before(ca.Cert.NotAfter) {
+		return nil, fmt.Errorf("pki: CA certificate expired on %s", ca.Cert.NotAfter.Format(time.RFC3339))
+	}
+	if tmpl.NotAfter.After(ca.Cert.NotAfter) {
+		tmpl.NotAfter = ca.Cert.NotAfter
+	}
+	tmpl.CRLDistributionPoints = ca.CRLDistributionPoints
+	tmpl.OCSPServer = ca.OCSPServers
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	chain := append([]*x509.Certificate{ca.Cert}, ca.Chain...)
+	return &Certificate{Cert: cert, Key: key, Chain: chain}, nil
+}
+
+// NewIntermediateCA issues a CA below ca, valid 5 years by default.
+func (ca *CA) NewIntermediateCA(req CertRequest) (*CA, error) {
+	if req.CommonName == "" {
+		return nil, errors.New("pki: CA needs a common name")
+	}
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(req, defaultIntermediateValidity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	tmpl.MaxPathLen = req.MaxPathLen
+	tmpl.MaxPathLenZero = req.MaxPathLen == 0
+	issued, err := ca.sign(tmpl, key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Certificate: *issued, CRLDistributionPoints: ca.CRLDistributionPoints, OCSPServers: ca.OCSPServers}, nil
+}
+
+// Issue signs a leaf certificate with the given extended key usages.
+func (ca *CA) Issue(req CertRequest, usages ...x509.ExtKeyUsage) (*Certificate, error) {
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(req, defaultLeafValidity)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Subject.CommonName == "" && len(req.DNSNames) > 0 {
+		tmpl.Subject.CommonName = req.DNSNames[0]
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		// RSA keys are still used for key encipherment by TLS 1.2 clients.
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	tmpl.ExtKeyUsage = usages
+	if len(req.ExtKeyUsage) > 0 {
+		tmpl.ExtKeyUsage = req.ExtKeyUsage
+	}
+	return ca.sign(tmpl, key)
+}
+
+// IssueServer issues a TLS server certificate, it needs at least one DNS name or IP.
+func (ca *CA) IssueServer(req CertRequest) (*Certificate, error) {
+	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+		return nil, ErrNoSubjectAltName
+	}
+	return ca.Issue(req, x509.ExtKeyUsageServerAuth)
+}
+
+// IssueClient issues a TLS client certificate, the identity is the common
+// name and any URI SAN.
+func (ca *CA) IssueClient(req CertRequest) (*Certificate, error) {
+	if req.CommonName == "" && len(req.URIs) == 0 {
+		return nil, errors.New("pki: client certificate needs a common name or URI")
+	}
+	return ca.Issue(req, x509.ExtKeyUsageClientAuth)
+}
+
+// IssuePeer issues a certificate for both TLS server and client use, for
+// agents that accept and make connections.
+func (ca *CA) IssuePeer(req CertRequest) (*Certificate, error) {
+	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+		return nil, ErrNoSubjectAltName
+	}
+	return ca.Issue(req, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+}
+
+// Revoke adds serial to the next CRL, reason is a RFC 5280 CRLReason, 0 for unspecified.
+func (ca *CA) Revoke(serial *big.Int, reason int) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if _, ok := ca.revocation(serial); ok {
+		return
+	}
+	ca.revoked = append(ca.revoked, x509.RevocationListEntry{
+		SerialNumber:   serial,
+		RevocationTime: time.Now().UTC(),
+		ReasonCode:     reason,
+	})
+}
+
+// IsRevoked reports whether serial was revoked and when.
+func (ca *CA) IsRevoked(serial *big.Int) (time.Time, int, bool) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	r, ok := ca.revocation(serial)
+	return r.RevocationTime, r.ReasonCode, ok
+}
+
+// revocation looks serial up, ca.mu must be held.
+func (ca *CA) revocation(serial *big.Int) (x509.RevocationListEntry, bool) {
+	for _, r := range ca.revoked {
+		if r.SerialNumber.Cmp(serial) == 0 {
+			return r, true
+		}
+	}
+	return x509.RevocationListEntry{}, false
+}
+
+// CRL returns a DER CRL of every revoked certificate, valid for validity,
+// 7 days by default. Each call increases the CRL number, RFC 5280 section
+// 5.2.3 requires it to grow for as long as the CA issues CRLs.
+func (ca *CA) CRL(validity time.Duration) ([]byte, error) {
+	if validity <= 0 {
+		validity = defaultCRLValidity
+	}
+	ca.mu.Lock()
+	number := big.NewInt(1)
+	if ca.crlNumber != nil {
+		number.Add(ca.crlNumber, number)
+	}
+	ca.crlNumber = number
+	tmpl := &x509.RevocationList{
+		RevokedCertificateEntries: append([]x509.RevocationListEntry(nil), ca.revoked...),
+		Number:                    new(big.Int).Set(number),
+		ThisUpdate:                time.Now().Add(-backdate),
+		NextUpdate:                time.Now().Add(validity),
+	}
+	ca.mu.Unlock()
+	return x509.CreateRevocationList(rand.Reader, tmpl, ca.Cert, ca.Key)
+}
+
+// LoadCRL restores the state of a CRL issued by ca, usually the last one
+// stored before a restart: its revocations are added and the next CRL gets
+// a higher number. der must be signed by ca.
+func (ca *CA) LoadCRL(der []byte) error {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return err
+	}
+	if err := crl.CheckSignatureFrom(ca.Cert); err != nil {
+		return fmt.Errorf("pki: CRL is not issued by this CA: %w", err)
+	}
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if crl.Number != nil && (ca.crlNumber == nil || crl.Number.Cmp(ca.crlNumber) > 0) {
+		ca.crlNumber = new(big.Int).Set(crl.Number)
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if _, ok := ca.revocation(entry.SerialNumber); ok {
+			continue
+		}
+		ca.revoked = append(ca.revoked, x509.RevocationListEntry{
+			SerialNumber:   entry.SerialNumber,
+			RevocationTime: entry.RevocationTime,
+			ReasonCode:     entry.ReasonCode,
+		})
+	}
+	return nil
+}
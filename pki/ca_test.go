@@ -0,0 +1,149 @@
+package pki
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) (*CA, *CA) {
+	root, err := NewRootCA(CertRequest{CommonName: "Test Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter, err := root.NewIntermediateCA(CertRequest{CommonName: "Test CA", Validity: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root, inter
+}
+
+func TestCAIssue(t *testing.T) {
+	root, inter := newTestCA(t)
+	leaf, err := inter.IssueServer(CertRequest{DNSNames: []string{"svc.internal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(inter.Cert)
+	if _, err := leaf.Cert.Verify(x509.VerifyOptions{DNSName: "svc.internal", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Cert.Subject.CommonName != "svc.internal" || inter.Root() != root.Cert {
+		t.Fatalf("common name %q", leaf.Cert.Subject.CommonName)
+	}
+
+	// the default 90 days are cut to the intermediate's 30
+	if !leaf.Cert.NotAfter.Equal(inter.Cert.NotAfter) {
+		t.Fatalf("leaf NotAfter %s past the issuer's %s", leaf.Cert.NotAfter, inter.Cert.NotAfter)
+	}
+	short, err := inter.IssueClient(CertRequest{CommonName: "agent-1", Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !short.Cert.NotAfter.Before(inter.Cert.NotAfter) {
+		t.Fatal("shorter validity clamped")
+	}
+
+	if _, err := inter.IssueServer(CertRequest{CommonName: "no-san"}); err != ErrNoSubjectAltName {
+		t.Fatalf("no SAN: %v", err)
+	}
+	if _, err := NewCA(leaf); err != ErrNotCA {
+		t.Fatalf("leaf as CA: %v", err)
+	}
+}
+
+func TestCARevocation(t *testing.T) {
+	_, inter := newTestCA(t)
+	leaf, err := inter.IssueClient(CertRequest{CommonName: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter.Revoke(leaf.Cert.SerialNumber, 1)
+	inter.Revoke(leaf.Cert.SerialNumber, 4)
+	if _, reason, ok := inter.IsRevoked(leaf.Cert.SerialNumber); !ok || reason != 1 {
+		t.Fatalf("revoked %v reason %d", ok, reason)
+	}
+	if _, _, ok := inter.IsRevoked(big.NewInt(42)); ok {
+		t.Fatal("unknown serial revoked")
+	}
+
+	var numbers []*big.Int
+	for i := 0; i < 2; i++ {
+		der, err := inter.CRL(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crl.CheckSignatureFrom(inter.Cert); err != nil {
+			t.Fatal(err)
+		}
+		if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(leaf.Cert.SerialNumber) != 0 {
+			t.Fatalf("CRL entries %v", crl.RevokedCertificateEntries)
+		}
+		numbers = append(numbers, crl.Number)
+	}
+	if numbers[1].Cmp(numbers[0]) <= 0 {
+		t.Fatalf("CRL numbers %v", numbers)
+	}
+}
+
+func TestCALoadCRL(t *testing.T) {
+	root, inter := newTestCA(t)
+	leaf, err := inter.IssueClient(CertRequest{CommonName: "agent-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter.Revoke(leaf.Cert.SerialNumber, 1)
+	var last []byte
+	for i := 0; i < 3; i++ {
+		if last, err = inter.CRL(time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the same CA after a restart
+	restarted, err := NewCA(&inter.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.LoadCRL(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, reason, ok := restarted.IsRevoked(leaf.Cert.SerialNumber); !ok || reason != 1 {
+		t.Fatalf("revocation lost: %v %d", ok, reason)
+	}
+	der, err := restarted.CRL(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, _ := x509.ParseRevocationList(der)
+	if crl.Number.Cmp(big.NewInt(4)) != 0 || len(crl.RevokedCertificateEntries) != 1 {
+		t.Fatalf("CRL number %v, %d entries", crl.Number, len(crl.RevokedCertificateEntries))
+	}
+	// an older CRL does not take the number back
+	if err := restarted.LoadCRL(last); err != nil {
+		t.Fatal(err)
+	}
+	der, _ = restarted.CRL(time.Hour)
+	if crl, _ := x509.ParseRevocationList(der); crl.Number.Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("CRL number %v after an older CRL", crl.Number)
+	}
+
+	other, err := root.CRL(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.LoadCRL(other); err == nil {
+		t.Fatal("CRL of another CA loaded")
+	}
+	if err := restarted.LoadCRL([]byte("not a CRL")); err == nil {
+		t.Fatal("garbage loaded")
+	}
+}
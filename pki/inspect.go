@@ -0,0 +1,321 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExpiryWarning = 30 * 24 * time.Hour
+	defaultMinRSABits    = 2048
+	defaultMinECDSABits  = 256
+)
+
+// CertInfo is what ops usually want to know about a certificate.
+type CertInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	DNSNames           []string  `json:"dns_names,omitempty"`
+	IPAddresses        []string  `json:"ip_addresses,omitempty"`
+	URIs               []string  `json:"uris,omitempty"`
+	EmailAddresses     []string  `json:"email_addresses,omitempty"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	KeyType            string    `json:"key_type"` // "RSA", "ECDSA" or "Ed25519"
+	KeySize            int       `json:"key_size"` // modulus or curve bits
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	IsCA               bool      `json:"is_ca"`
+	FingerprintSHA256  string    `json:"fingerprint_sha256"` // colon separated hex, as openssl prints it
+	Warnings           []string  `json:"warnings,omitempty"`
+}
+
+// ChainReport is the result of inspecting a chain, leaf first.
+type ChainReport struct {
+	Certificates []CertInfo `json:"certificates"`
+	// Verified is set when the chain leads to a trusted root and, if a
+	// hostname was given, the leaf is valid for it.
+	Verified      bool     `json:"verified"`
+	VerifyError   string   `json:"verify_error,omitempty"`
+	Hostname      string   `json:"hostname,omitempty"`
+	HostnameMatch bool     `json:"hostname_match"`
+	TrustedChain  []string `json:"trusted_chain,omitempty"` // subjects up to the root
+	// Warnings collects the warnings of every certificate.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// InspectOptions tune InspectChain. The zero value verifies against the
+// system roots at the current time.
+type InspectOptions struct {
+	Roots         *x509.CertPool // system roots when nil
+	Hostname      string         // checked against the leaf when set
+	ExpiryWarning time.Duration  // warn when a certificate expires sooner, 30 days by default
+	MinRSABits    int            // weaker RSA keys are flagged, 2048 by default
+	MinECDSABits  int            // smaller curves are flagged, 256 (P-256) by default
+	Now           time.Time      // time.Now() when zero
+}
+
+func (o InspectOptions) now() time.Time {
+	if o.Now.IsZero() {
+		return time.Now()
+	}
+	return o.Now
+}
+
+// fingerprint returns the SHA-256 of the DER, as AA:BB:...
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(h); i += 2 {
+		parts = append(parts, h[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// InspectCertificate describes cert and flags weak keys, weak signatures and
+// certificates that are expired, not yet valid or expiring soon.
+func InspectCertificate(cert *x509.Certificate, opts InspectOptions) CertInfo {
+	keyType, keySize := publicKeyInfo(cert)
+	info := CertInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%X", cert.SerialNumber),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		KeyType:            keyType,
+		KeySize:            keySize,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+		FingerprintSHA256:  fingerprint(cert),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+
+	minRSA := opts.MinRSABits
+	if minRSA == 0 {
+		minRSA = defaultMinRSABits
+	}
+	if keyType == "RSA" && keySize < minRSA {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("weak key: RSA %d bits", keySize))
+	}
+	minECDSA := opts.MinECDSABits
+	if minECDSA == 0 {
+		minECDSA = defaultMinECDSABits
+	}
+	if keyType == "ECDSA" && keySize < minECDSA {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("weak key: ECDSA %d bits", keySize))
+	}
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		// a self-signed root's own signature is never checked, so it does not matter there
+		if cert.CheckSignatureFrom(cert) != nil {
+			info.Warnings = append(info.Warnings, "weak signature: "+cert.SignatureAlgorithm.String())
+		}
+	}
+
+	now := opts.now()
+	warn := opts.ExpiryWarning
+	if warn == 0 {
+		warn = defaultExpiryWarning
+	}
+	switch {
+	case now.After(cert.NotAfter):
+		info.Warnings = append(info.Warnings, fmt.Sprintf("expired %s", cert.NotAfter.UTC().Format(time.RFC3339)))
+	case now.Before(cert.NotBefore):
+		info.Warnings = append(info.Warnings, fmt.Sprintf("not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339)))
+	case cert.NotAfter.Sub(now) < warn:
+		info.Warnings = append(info.Warnings, fmt.Sprintf("expires in %s", cert.NotAfter.Sub(now).Round(time.Hour)))
+	}
+	return info
+}
+
+// InspectChain describes certs, leaf first, and verifies them. Certificates
+// after the leaf are only used as intermediates, never trusted by themselves.
+func InspectChain(certs []*x509.Certificate, opts InspectOptions) *ChainReport {
+	report := &ChainReport{Hostname: opts.Hostname}
+	if len(certs) == 0 {
+		report.VerifyError = ErrNoCertificate.Error()
+		return report
+	}
+	for i, cert := range certs {
+		info := InspectCertificate(cert, opts)
+		report.Certificates = append(report.Certificates, info)
+		for _, w := range info.Warnings {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("certificate %d (%s): %s", i, cert.Subject.CommonName, w))
+		}
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   opts.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		report.VerifyError = err.Error()
+	} else {
+		for _, cert := range chains[0] {
+			report.TrustedChain = append(report.TrustedChain, cert.Subject.String())
+		}
+	}
+	if opts.Hostname != "" {
+		if err := leaf.VerifyHostname(opts.Hostname); err != nil {
+			report.Warnings = append(report.Warnings, err.Error())
+		} else {
+			report.HostnameMatch = true
+		}
+	}
+	report.Verified = err == nil && (opts.Hostname == "" || report.HostnameMatch)
+	return report
+}
+
+// InspectChainPEM is InspectChain for a PEM bundle, leaf first.
+func InspectChainPEM(data []byte, opts InspectOptions) (*ChainReport, error) {
+	certs, err := ParseCertificatesPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	return InspectChain(certs, opts), nil
+}
+
+// InspectRemote connects to addr ("host:port") and inspects the chain the
+// server actually sends. The handshake itself does not verify anything, so
+// broken deployments can be looked at too; the report says what is wrong.
+// opts.Hostname defaults to the host of addr and is also sent as SNI.
+func InspectRemote(addr string, opts InspectOptions, timeout time.Duration) (*ChainReport, error) {
+	if opts.Hostname == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		opts.Hostname = host
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         opts.Hostname,
+		InsecureSkipVerify: true, // verified by InspectChain instead
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return InspectChain(conn.ConnectionState().PeerCertificates, opts), nil
+}
+
+// String renders the report for a terminal.
+func (r *ChainReport) String() string {
+	var b strings.Builder
+	for i, c := range r.Certificates {
+		fmt.Fprintf(&b, "[%d] %s\n", i, c.Subject)
+		fmt.Fprintf(&b, "    issuer:      %s\n", c.Issuer)
+		fmt.Fprintf(&b, "    serial:      %s\n", c.SerialNumber)
+		if sans := append(append(append(append([]string(nil), c.DNSNames...), c.IPAddresses...), c.URIs...), c.EmailAddresses...); len(sans) > 0 {
+			fmt.Fprintf(&b, "    SANs:        %s\n", strings.Join(sans, ", "))
+		}
+		fmt.Fprintf(&b, "    valid:       %s to %s\n", c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339))
+		fmt.Fprintf(&b, "    key:         %s %d\n", c.KeyType, c.KeySize)
+		fmt.Fprintf(&b, "    signature:   %s\n", c.SignatureAlgorithm)
+		fmt.Fprintf(&b, "    sha256:      %s\n", c.FingerprintSHA256)
+	}
+	if r.Verified {
+		fmt.Fprintf(&b, "verified: yes, %s\n", strings.Join(r.TrustedChain, " > "))
+	} else {
+		fmt.Fprintf(&b, "verified: no\n")
+		if r.VerifyError != "" {
+			fmt.Fprintf(&b, "    %s\n", r.VerifyError)
+		}
+	}
+	if r.Hostname != "" {
+		fmt.Fprintf(&b, "hostname %s: %v\n", r.Hostname, r.HostnameMatch)
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&b, "warning: %s\n", w)
+	}
+	return b.String()
+}
+
+// InspectHandler answers with a JSON ChainReport, for
+//
+//	GET ?addr=host:port[&hostname=name]   the chain deployed at addr
+//	POST <PEM bundle>[?hostname=name]     an uploaded chain
+//
+// Only mount it behind authentication, as GET makes outbound connections.
+func InspectHandler(opts InspectOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := opts
+		if h := r.URL.Query().Get("hostname"); h != "" {
+			opts.Hostname = h
+		}
+		var report *ChainReport
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			addr := r.URL.Query().Get("addr")
+			if addr == "" {
+				http.Error(w, "addr is required", http.StatusBadRequest)
+				return
+			}
+			if _, _, splitErr := net.SplitHostPort(addr); splitErr != nil {
+				addr = net.JoinHostPort(addr, "443")
+			}
+			report, err = InspectRemote(addr, opts, 0)
+		case http.MethodPost:
+			body, readErr := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+			if readErr == nil {
+				report, readErr = InspectChainPEM(body, opts)
+			}
+			if readErr != nil {
+				http.Error(w, readErr.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+}
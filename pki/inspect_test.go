@@ -0,0 +1,154 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hasWarning(warnings []string, substr string) bool {
+	for _, w := range warnings {
+		if strings.Contains(w, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInspectChain(t *testing.T) {
+	root, inter := newTestCA(t)
+	leaf, err := inter.IssueServer(CertRequest{DNSNames: []string{"svc.internal"}, Validity: 7 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	chain := []*x509.Certificate{leaf.Cert, inter.Cert}
+	opts := InspectOptions{Roots: roots, Hostname: "svc.internal", ExpiryWarning: time.Hour}
+
+	report := InspectChain(chain, opts)
+	if !report.Verified || !report.HostnameMatch || report.VerifyError != "" || len(report.Warnings) != 0 {
+		t.Fatalf("report %+v", report)
+	}
+	if len(report.TrustedChain) != 3 || report.TrustedChain[2] != root.Cert.Subject.String() {
+		t.Fatalf("trusted chain %v", report.TrustedChain)
+	}
+	info := report.Certificates[0]
+	if info.KeyType != "ECDSA" || info.KeySize != 256 || info.DNSNames[0] != "svc.internal" || info.IsCA || !report.Certificates[1].IsCA {
+		t.Fatalf("leaf %+v", info)
+	}
+	if len(info.FingerprintSHA256) != 32*3-1 {
+		t.Fatalf("fingerprint %s", info.FingerprintSHA256)
+	}
+
+	pemReport, err := InspectChainPEM(EncodeCertificatesPEM(chain...), opts)
+	if err != nil || !pemReport.Verified {
+		t.Fatalf("PEM: %v %+v", err, pemReport)
+	}
+	if !strings.Contains(report.String(), "verified: yes") {
+		t.Fatalf("rendered:\n%s", report)
+	}
+
+	// the intermediate sent along is not trusted by itself
+	opts.Roots = x509.NewCertPool()
+	if report := InspectChain(chain, opts); report.Verified || report.VerifyError == "" {
+		t.Fatalf("untrusted chain verified: %+v", report)
+	}
+	if report := InspectChain(nil, InspectOptions{}); report.Verified || report.VerifyError != ErrNoCertificate.Error() {
+		t.Fatalf("empty chain: %+v", report)
+	}
+}
+
+func TestInspectChainHostname(t *testing.T) {
+	root, inter := newTestCA(t)
+	leaf, err := inter.IssueServer(CertRequest{DNSNames: []string{"svc.internal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	report := InspectChain([]*x509.Certificate{leaf.Cert, inter.Cert}, InspectOptions{Roots: roots, Hostname: "other.internal", ExpiryWarning: time.Hour})
+	if report.Verified || report.HostnameMatch {
+		t.Fatalf("other hostname verified: %+v", report)
+	}
+	if report.VerifyError != "" || !hasWarning(report.Warnings, "other.internal") {
+		t.Fatalf("mismatch not reported: %+v", report)
+	}
+	if !strings.Contains(report.String(), "hostname other.internal: false") {
+		t.Fatalf("rendered:\n%s", report)
+	}
+}
+
+func TestInspectCertificateWarnings(t *testing.T) {
+	_, inter := newTestCA(t)
+	leaf, err := inter.IssueClient(CertRequest{CommonName: "agent-1", Validity: 10 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := leaf.Cert
+	for _, tc := range []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{"expiring", cert.NotAfter.Add(-24 * time.Hour), "expires in 24h0m0s"},
+		{"expired", cert.NotAfter.Add(time.Second), "expired " + cert.NotAfter.UTC().Format(time.RFC3339)},
+		{"not yet valid", cert.NotBefore.Add(-time.Second), "not valid before"},
+	} {
+		info := InspectCertificate(cert, InspectOptions{Now: tc.now})
+		if len(info.Warnings) != 1 || !strings.HasPrefix(info.Warnings[0], tc.want) {
+			t.Errorf("%s: %v", tc.name, info.Warnings)
+		}
+	}
+	// 10 days left is within the default 30 day warning, not within 5 days
+	if info := InspectCertificate(cert, InspectOptions{}); !hasWarning(info.Warnings, "expires in") {
+		t.Errorf("default warning: %v", info.Warnings)
+	}
+	if info := InspectCertificate(cert, InspectOptions{ExpiryWarning: 5 * 24 * time.Hour}); len(info.Warnings) != 0 {
+		t.Errorf("5 day warning: %v", info.Warnings)
+	}
+}
+
+func TestInspectCertificateWeakKeys(t *testing.T) {
+	_, inter := newTestCA(t)
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := InspectOptions{ExpiryWarning: time.Hour}
+	for _, tc := range []struct {
+		name string
+		req  CertRequest
+		opts InspectOptions
+		want string
+	}{
+		{"P-224", CertRequest{CommonName: "p224", Key: p224}, opts, "weak key: ECDSA 224 bits"},
+		{"RSA 1024", CertRequest{CommonName: "rsa1024", Key: rsa1024}, opts, "weak key: RSA 1024 bits"},
+		{"P-384", CertRequest{CommonName: "p384", Key: p384}, opts, ""},
+		{"P-256", CertRequest{CommonName: "p256", KeyType: KeyECDSAP256}, opts, ""},
+		{"P-256 below the minimum", CertRequest{CommonName: "p256", KeyType: KeyECDSAP256}, InspectOptions{ExpiryWarning: time.Hour, MinECDSABits: 384}, "weak key: ECDSA 256 bits"},
+		{"Ed25519", CertRequest{CommonName: "ed25519", KeyType: KeyEd25519}, opts, ""},
+	} {
+		leaf, err := inter.IssueClient(tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		info := InspectCertificate(leaf.Cert, tc.opts)
+		if tc.want == "" && len(info.Warnings) != 0 || tc.want != "" && (len(info.Warnings) != 1 || info.Warnings[0] != tc.want) {
+			t.Errorf("%s: %v", tc.name, info.Warnings)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const defaultOCSPValidity = 24 * time.Hour
+
+// OCSPHandler answers OCSP requests for certificates issued by ca from its
+// revocation list, https://tools.ietf.org/html/rfc6960. Responses are signed
+// by the CA itself and valid for validity, 24 hours by default. Serials that
+// were not revoked are reported good, the CA does not track what it issued.
+// The CA key must be RSA or ECDSA.
+func (ca *CA) OCSPHandler(validity time.Duration) http.Handler {
+	if validity <= 0 {
+		validity = defaultOCSPValidity
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var der []byte
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			der, err = io.ReadAll(http.MaxBytesReader(w, r.Body, 10<<10))
+		case http.MethodGet:
+			// GET {url}/{url-encoding of base64 of the DER request}, RFC 6960 appendix A.1
+			// from the escaped path, base64 may contain an encoded "/"
+			path := r.URL.EscapedPath()
+			var b64 string
+			b64, err = url.PathUnescape(path[strings.LastIndex(path, "/")+1:])
+			if err == nil {
+				der, err = base64.StdEncoding.DecodeString(b64)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			writeOCSP(w, ocsp.MalformedRequestErrorResponse, 0)
+			return
+		}
+		resp, err := ca.ocspResponse(der, validity)
+		if err != nil {
+			writeOCSP(w, ocsp.MalformedRequestErrorResponse, 0)
+			return
+		}
+		writeOCSP(w, resp, validity)
+	})
+}
+
+func writeOCSP(w http.ResponseWriter, resp []byte, maxAge time.Duration) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", int(maxAge/time.Second)))
+	}
+	w.Write(resp)
+}
+
+// ocspResponse builds the signed response to a DER request.
+func (ca *CA) ocspResponse(der []byte, validity time.Duration) ([]byte, error) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	// only answer for our own key, RFC 6960 section 4.1.1
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.Cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	if !req.HashAlgorithm.Available() {
+		return nil, fmt.Errorf("pki: OCSP hash %v not available", req.HashAlgorithm)
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now.Add(-backdate),
+		NextUpdate:   now.Add(validity),
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if at, reason, ok := ca.IsRevoked(req.SerialNumber); ok {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = at
+		template.RevocationReason = reason
+	}
+	return ocsp.CreateResponse(ca.Cert, ca.Cert, template, ca.Key)
+}
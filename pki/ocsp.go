@@ -0,0 +1,220 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// ErrNoOCSPServer is returned for certificates without an OCSP responder in their AIA.
+	ErrNoOCSPServer = errors.New("pki: certificate has no OCSP server")
+	// ErrCertificateRevoked is returned when the responder reports the certificate revoked.
+	ErrCertificateRevoked = errors.New("pki: certificate is revoked")
+)
+
+type ocspEntry struct {
+	raw       []byte
+	resp      *ocsp.Response
+	refreshAt time.Time
+	notified  bool
+}
+
+// OCSPStapler fetches OCSP responses from the responder named in each
+// certificate, caches them and refetches halfway to their nextUpdate, so a
+// fresh response is always at hand for tls.Certificate.OCSPStaple.
+type OCSPStapler struct {
+	Client *http.Client // a client with a 10 second timeout when nil
+	// OnRevoked is called once per certificate found revoked, to renew it early.
+	OnRevoked func(leaf *x509.Certificate, resp *ocsp.Response)
+	Now       func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]*ocspEntry // by leaf fingerprint
+	inflight map[string]*ocspCall
+}
+
+// ocspCall is a fetch in progress, done is closed once its results are set.
+type ocspCall struct {
+	done chan struct{}
+	resp *ocsp.Response
+	raw  []byte
+	err  error
+}
+
+func NewOCSPStapler() *OCSPStapler {
+	return &OCSPStapler{cache: make(map[string]*ocspEntry), inflight: make(map[string]*ocspCall)}
+}
+
+func (s *OCSPStapler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *OCSPStapler) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Status returns the OCSP response for leaf, from the cache while it is
+// fresh. When a refresh fails the cached response is kept until its
+// nextUpdate, responders are allowed to be down for a while. Concurrent
+// callers for the same leaf share one request.
+func (s *OCSPStapler) Status(leaf *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	key := fingerprint(leaf)
+	now := s.now()
+	s.mu.Lock()
+	if s.cache == nil {
+		s.cache = make(map[string]*ocspEntry)
+	}
+	if s.inflight == nil {
+		s.inflight = make(map[string]*ocspCall)
+	}
+	entry := s.cache[key]
+	if entry != nil && now.Before(entry.refreshAt) {
+		s.mu.Unlock()
+		return entry.resp, entry.raw, nil
+	}
+	if call, ok := s.inflight[key]; ok {
+		s.mu.Unlock()
+		<-call.done
+		return call.resp, call.raw, call.err
+	}
+	call := &ocspCall{done: make(chan struct{})}
+	s.inflight[key] = call
+	s.mu.Unlock()
+
+	call.resp, call.raw, call.err = s.refresh(key, leaf, issuer, entry, now)
+	s.mu.Lock()
+	delete(s.inflight, key)
+	s.mu.Unlock()
+	close(call.done)
+	return call.resp, call.raw, call.err
+}
+
+// refresh fetches a new response for leaf and caches it, falling back to
+// entry, the cached one, if it is still valid.
+func (s *OCSPStapler) refresh(key string, leaf *x509.Certificate, issuer *x509.Certificate, entry *ocspEntry, now time.Time) (*ocsp.Response, []byte, error) {
+	resp, raw, err := s.fetch(leaf, issuer)
+	if err != nil {
+		if entry != nil && (entry.resp.NextUpdate.IsZero() || now.Before(entry.resp.NextUpdate)) {
+			return entry.resp, entry.raw, nil
+		}
+		return nil, nil, err
+	}
+	refreshAt := now.Add(time.Hour)
+	if !resp.NextUpdate.IsZero() {
+		refreshAt = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	}
+	next := &ocspEntry{raw: raw, resp: resp, refreshAt: refreshAt}
+	notify := false
+	s.mu.Lock()
+	if old := s.cache[key]; old != nil {
+		next.notified = old.notified
+	}
+	if resp.Status == ocsp.Revoked && !next.notified {
+		next.notified = true
+		notify = s.OnRevoked != nil
+	}
+	s.cache[key] = next
+	s.mu.Unlock()
+	if notify {
+		s.OnRevoked(leaf, resp)
+	}
+	return resp, raw, nil
+}
+
+// fetch POSTs a request to the first OCSP server of leaf and checks the
+// response is signed by issuer or a responder it delegated to.
+func (s *OCSPStapler) fetch(leaf *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, []byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, ErrNoOCSPServer
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp, err := s.client().Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("pki: OCSP responder answered %s", httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !resp.NextUpdate.IsZero() && s.now().After(resp.NextUpdate) {
+		return nil, nil, errors.New("pki: OCSP response is stale")
+	}
+	return resp, raw, nil
+}
+
+// Staple sets cert.OCSPStaple to a good response. The chain of cert must
+// include the issuer. A revoked certificate gets no staple and
+// ErrCertificateRevoked.
+func (s *OCSPStapler) Staple(cert *tls.Certificate) error {
+	if len(cert.Certificate) < 2 {
+		return errors.New("pki: stapling needs the issuer in the chain")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return err
+	}
+	resp, raw, err := s.Status(leaf, issuer)
+	if err != nil {
+		return err
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		cert.OCSPStaple = raw
+		return nil
+	case ocsp.Revoked:
+		cert.OCSPStaple = nil
+		return ErrCertificateRevoked
+	}
+	cert.OCSPStaple = nil
+	return errors.New("pki: OCSP status unknown")
+}
+
+// GetCertificate wraps a tls.Config.GetCertificate callback so each served
+// certificate carries a staple. Stapling errors are not fatal, the
+// certificate is then served without one. A refresh due blocks the handshake
+// for up to the client timeout.
+func (s *OCSPStapler) GetCertificate(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if err != nil || cert == nil {
+			return cert, err
+		}
+		// copy, the certificate may be shared by concurrent handshakes
+		stapled := *cert
+		s.Staple(&stapled)
+		return &stapled, nil
+	}
+}
@@ -0,0 +1,210 @@
+package pki
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// countingTransport counts OCSP requests, optionally slowing them down.
+type countingTransport struct {
+	n     int32
+	delay time.Duration
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.n, 1)
+	time.Sleep(t.delay)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (t *countingTransport) count() int {
+	return int(atomic.LoadInt32(&t.n))
+}
+
+// clock is a settable OCSPStapler.Now.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// newOCSPResponderStandIn serves ca.OCSPHandler locally and adds its URL to
+// ca.OCSPServers, so certificates issued afterwards point at it.
+func newOCSPResponderStandIn(ca *CA, validity time.Duration) *httptest.Server {
+	srv := httptest.NewServer(ca.OCSPHandler(validity))
+	ca.OCSPServers = append(ca.OCSPServers, srv.URL)
+	return srv
+}
+
+type ocspFixture struct {
+	ca        *CA
+	leaf      *Certificate
+	stapler   *OCSPStapler
+	transport *countingTransport
+	clock     *clock
+	start     time.Time
+	close     func()
+}
+
+// newOCSPFixture issues a leaf from an intermediate CA answering OCSP
+// through newOCSPResponderStandIn, responses valid for an hour.
+func newOCSPFixture(t *testing.T) *ocspFixture {
+	root, err := NewRootCA(CertRequest{CommonName: "OCSP Test Root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter, err := root.NewIntermediateCA(CertRequest{CommonName: "OCSP Test CA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newOCSPResponderStandIn(inter, time.Hour)
+	t.Cleanup(srv.Close)
+	leaf, err := inter.IssueServer(CertRequest{DNSNames: []string{"svc.internal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.Cert.OCSPServer) != 1 || leaf.Cert.OCSPServer[0] != srv.URL {
+		t.Fatalf("leaf OCSP servers %v, want %s", leaf.Cert.OCSPServer, srv.URL)
+	}
+	f := &ocspFixture{ca: inter, leaf: leaf, transport: &countingTransport{}, start: time.Now(), close: srv.Close}
+	f.clock = &clock{now: f.start}
+	f.stapler = NewOCSPStapler()
+	f.stapler.Client = &http.Client{Transport: f.transport, Timeout: 5 * time.Second}
+	f.stapler.Now = f.clock.Now
+	return f
+}
+
+func TestOCSPStaplerGood(t *testing.T) {
+	f := newOCSPFixture(t)
+	cert := f.leaf.TLSCertificate()
+	if err := f.stapler.Staple(&cert); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ocsp.ParseResponseForCert(cert.OCSPStaple, f.leaf.Cert, f.ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Fatalf("status %d, want good", resp.Status)
+	}
+}
+
+func TestOCSPStaplerRevoked(t *testing.T) {
+	f := newOCSPFixture(t)
+	var calls int32
+	f.stapler.OnRevoked = func(leaf *x509.Certificate, resp *ocsp.Response) {
+		atomic.AddInt32(&calls, 1)
+		if leaf.SerialNumber.Cmp(f.leaf.Cert.SerialNumber) != 0 || resp.RevocationReason != ocsp.KeyCompromise {
+			t.Errorf("OnRevoked for serial %v reason %d", leaf.SerialNumber, resp.RevocationReason)
+		}
+	}
+	f.ca.Revoke(f.leaf.Cert.SerialNumber, ocsp.KeyCompromise)
+
+	for i := 0; i < 3; i++ {
+		// every call past the refresh point fetches again
+		f.clock.Set(f.start.Add(time.Duration(i) * 28 * time.Minute))
+		cert := f.leaf.TLSCertificate()
+		if err := f.stapler.Staple(&cert); !errors.Is(err, ErrCertificateRevoked) {
+			t.Fatalf("got %v, want ErrCertificateRevoked", err)
+		}
+		if cert.OCSPStaple != nil {
+			t.Fatal("revoked certificate stapled")
+		}
+	}
+	if f.transport.count() != 3 {
+		t.Fatalf("%d fetches, want 3", f.transport.count())
+	}
+	if calls != 1 {
+		t.Fatalf("OnRevoked called %d times, want once", calls)
+	}
+}
+
+func TestOCSPStaplerRefreshAtHalfLife(t *testing.T) {
+	f := newOCSPFixture(t)
+	first, _, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := first.ThisUpdate.Add(first.NextUpdate.Sub(first.ThisUpdate) / 2)
+
+	f.clock.Set(half.Add(-time.Minute))
+	if _, _, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert); err != nil {
+		t.Fatal(err)
+	}
+	if f.transport.count() != 1 {
+		t.Fatalf("%d fetches before half life, want 1", f.transport.count())
+	}
+
+	f.clock.Set(half.Add(time.Minute))
+	if _, _, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert); err != nil {
+		t.Fatal(err)
+	}
+	if f.transport.count() != 2 {
+		t.Fatalf("%d fetches after half life, want 2", f.transport.count())
+	}
+}
+
+func TestOCSPStaplerResponderDown(t *testing.T) {
+	f := newOCSPFixture(t)
+	first, raw, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.close()
+
+	// past the refresh point but before nextUpdate the cached response is served
+	f.clock.Set(first.NextUpdate.Add(-time.Minute))
+	resp, got, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert)
+	if err != nil {
+		t.Fatalf("stale response not served: %v", err)
+	}
+	if resp != first || string(got) != string(raw) {
+		t.Fatal("a different response was served")
+	}
+	if f.transport.count() != 2 {
+		t.Fatalf("%d fetches, want 2", f.transport.count())
+	}
+
+	f.clock.Set(first.NextUpdate.Add(time.Minute))
+	if _, _, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert); err == nil {
+		t.Fatal("expired response served")
+	}
+}
+
+func TestOCSPStaplerSharedFetch(t *testing.T) {
+	f := newOCSPFixture(t)
+	f.transport.delay = 50 * time.Millisecond
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := f.stapler.Status(f.leaf.Cert, f.ca.Cert); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if f.transport.count() != 1 {
+		t.Fatalf("%d fetches for concurrent callers, want 1", f.transport.count())
+	}
+}
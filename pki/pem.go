@@ -0,0 +1,115 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"mygolibs/encrypt"
+	"os"
+)
+
+// ErrNoCertificate is returned for PEM data without a CERTIFICATE block.
+var ErrNoCertificate = errors.New("pki: no certificate found")
+
+// EncodeCertificatesPEM encodes certs as consecutive CERTIFICATE blocks.
+func EncodeCertificatesPEM(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+// EncodeCRLPEM wraps a DER CRL from CA.CRL in an "X509 CRL" block.
+func EncodeCRLPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// ParseCertificatesPEM returns every CERTIFICATE block of data, in order,
+// skipping other blocks such as keys.
+func ParseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return certs, nil
+}
+
+// CertPEM returns the certificate followed by its chain, the self-signed
+// root left out since peers must already trust it. This is what a TLS
+// server sends.
+func (c *Certificate) CertPEM() []byte {
+	certs := []*x509.Certificate{c.Cert}
+	for _, cert := range c.Chain {
+		if cert.CheckSignatureFrom(cert) == nil {
+			break
+		}
+		certs = append(certs, cert)
+	}
+	return EncodeCertificatesPEM(certs...)
+}
+
+// KeyPEM returns the private key as PKCS#8.
+func (c *Certificate) KeyPEM() ([]byte, error) {
+	return encrypt.MarshalPrivateKeyPEM(c.Key, nil)
+}
+
+// WriteFiles writes CertPEM to certFile and KeyPEM to keyFile, readable by
+// the owner only.
+func (c *Certificate) WriteFiles(certFile string, keyFile string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, c.CertPEM(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+// LoadCertificate parses a PEM chain, leaf first, and its private key in any
+// form encrypt.ParsePrivateKey reads. The key must match the leaf.
+func LoadCertificate(certPEM []byte, keyPEM []byte, passphrase []byte) (*Certificate, error) {
+	certs, err := ParseCertificatesPEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encrypt.ParsePrivateKey(keyPEM, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(certs[0].PublicKey) {
+		return nil, errors.New("pki: private key does not match the certificate")
+	}
+	return &Certificate{Cert: certs[0], Key: key, Chain: certs[1:]}, nil
+}
+
+// LoadCertificateFiles is LoadCertificate for files on disk.
+func LoadCertificateFiles(certFile string, keyFile string, passphrase []byte) (*Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCertificate(certPEM, keyPEM, passphrase)
+}
@@ -0,0 +1,78 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// TLSCertificate returns c for tls.Config.Certificates, with the chain but
+// not the root.
+func (c *Certificate) TLSCertificate() tls.Certificate {
+	out := tls.Certificate{
+		Certificate: [][]byte{c.Cert.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Cert,
+	}
+	for _, cert := range c.Chain {
+		if cert.CheckSignatureFrom(cert) == nil {
+			break
+		}
+		out.Certificate = append(out.Certificate, cert.Raw)
+	}
+	return out
+}
+
+// CertPool returns a pool trusting certs.
+func CertPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// ServerTLSConfig serves cert. With clientCAs set, clients must present a
+// certificate issued by one of them.
+func ServerTLSConfig(cert *Certificate, clientCAs *x509.CertPool) *tls.Config {
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert.TLSCertificate()},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		conf.ClientCAs = clientCAs
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf
+}
+
+// ClientTLSConfig trusts roots, the system roots when nil, and presents cert
+// when it is not nil. serverName overrides the host name checked against the
+// server certificate.
+func ClientTLSConfig(cert *Certificate, roots *x509.CertPool, serverName string) *tls.Config {
+	conf := &tls.Config{
+		RootCAs:    roots,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cert != nil {
+		conf.Certificates = []tls.Certificate{cert.TLSCertificate()}
+	}
+	return conf
+}
+
+// HTTPTransport returns a transport using conf, for http.Client or
+// resty.Client.SetTransport.
+func HTTPTransport(conf *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
+	return transport
+}
+
+// PeerCertificate returns the verified client certificate of an mTLS request.
+func PeerCertificate(req *http.Request) (*x509.Certificate, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+	return req.TLS.VerifiedChains[0][0], true
+}